@@ -0,0 +1,142 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/kurrik/twittergo"
+	"github.com/pkg/errors"
+)
+
+// The authenticated user's screen name, lower case, as configured.
+func (fs *fsOps) ownScreenName() string {
+	return strings.ToLower(fs.config.ScreenName)
+}
+
+// Updates a tweet known to the file system with the tweet returned
+// after an action on it, e.g., with the new count of likes.
+func (fs *fsOps) updateTweet(tweet twittergo.Tweet) {
+	if tweet != nil && fs.tweets.get(tweet.IdStr()) != nil {
+		fs.tweets.put(tweet)
+	}
+}
+
+// Calls the API to act on a tweet, without holding the lock.
+func (fs *fsOps) tweetAction(call func(*twittergo.Client, string) (twittergo.Tweet, error), idStr string) (tweet twittergo.Tweet, err error) {
+	fs.unlocked(func() {
+		tweet, err = call(fs.client, idStr)
+	})
+	return tweet, err
+}
+
+func (fs *fsOps) like(idStr string) error {
+	tweet, err := fs.tweetAction(apiFavoritesCreate, idStr)
+	if err != nil {
+		return err
+	}
+	fs.updateTweet(tweet)
+	return nil
+}
+
+func (fs *fsOps) unlike(idStr string) error {
+	tweet, err := fs.tweetAction(apiFavoritesDestroy, idStr)
+	if err != nil {
+		return err
+	}
+	fs.updateTweet(tweet)
+	return nil
+}
+
+// Retweets the tweet, adding the retweet to the authenticated user's
+// directory.
+func (fs *fsOps) retweet(idStr string) error {
+	wrapper, err := fs.tweetAction(apiStatusesRetweet, idStr)
+	if err != nil {
+		return err
+	}
+	fs.updateTweet(retweetedStatus(wrapper))
+	fs.addOwnTweet(wrapper)
+	return nil
+}
+
+// Undoes a retweet, removing the retweet from all timelines.
+func (fs *fsOps) unretweet(idStr string) error {
+	tweet, err := fs.tweetAction(apiStatusesUnretweet, idStr)
+	if err != nil {
+		return err
+	}
+	fs.updateTweet(tweet)
+	me := fs.ownScreenName()
+	for _, n := range fs.tweets.tweets {
+		if retweeted := retweetedStatus(n.data); n.author == me && retweeted != nil && retweeted.IdStr() == idStr {
+			fs.tweets.remove(n)
+		}
+	}
+	return nil
+}
+
+// Deletes one of the authenticated user's tweets, removing it from
+// all timelines.
+func (fs *fsOps) deleteTweet(idStr string) error {
+	if _, err := fs.tweetAction(apiStatusesDestroy, idStr); err != nil {
+		return err
+	}
+	if n := fs.tweets.get(idStr); n != nil {
+		fs.tweets.remove(n)
+	}
+	return nil
+}
+
+// Adapts an action on a tweet to a control file command.
+func tweetCommand(action func(fs *fsOps, idStr string) error) func(*fsOps, *node, []string) error {
+	return func(fs *fsOps, _ *node, args []string) error {
+		if !idStrExpr.MatchString(args[0]) {
+			return errors.Errorf("%q: not a tweet id", args[0])
+		}
+		return action(fs, args[0])
+	}
+}
+
+// Follows the user, adding their directory if needed.
+func (fs *fsOps) follow(screenName string) (*node, error) {
+	if !screenNameExpr.MatchString(screenName) {
+		return nil, errors.Errorf("%q: not a screen name", screenName)
+	}
+	var u twitterUser
+	var err error
+	fs.unlocked(func() {
+		u, err = apiFriendshipsCreate(fs.client, screenName)
+	})
+	if err != nil {
+		return nil, err
+	}
+	users := fs.root.children["users"]
+	user, ok := users.children[u.ScreenName]
+	if !ok {
+		user = users.addUser(u)
+		users.prepareDirEntries()
+	}
+	user.followed = true
+	return user, nil
+}
+
+// Unfollows the user, removing their directory.
+func (fs *fsOps) unfollow(user *node) error {
+	if !user.followed {
+		return errors.Errorf("%s: not followed", user.dir.Name)
+	}
+	screenName := user.dir.Name
+	var err error
+	fs.unlocked(func() {
+		_, err = apiFriendshipsDestroy(fs.client, screenName)
+	})
+	if err != nil {
+		return err
+	}
+	// Another request may have removed the user meanwhile.
+	users := fs.root.children["users"]
+	if users.children[screenName] == user {
+		users.removeUser(fs.tweets, user)
+		users.prepareDirEntries()
+	}
+	return nil
+}
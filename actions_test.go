@@ -0,0 +1,160 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTweetActions(t *testing.T) {
+	fake := newFakeTwitter()
+	defer fake.close()
+	fs, cleanup := newTestFileSystem(t, fake.client(), &fsConfig{ScreenName: "Janet", Metadata: true})
+	defer cleanup()
+	idStr := fake.addTweet("john", "Hello").IdStr()
+	home := fs.root.children["home"]
+	if err := fs.ensureLoaded(home); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	tweet := home.children[idStr]
+	if tweet == nil {
+		t.Fatal("tweet not loaded")
+	}
+	run := func(cmd string) {
+		t.Helper()
+		if err := fs.runCtl(fs.root, cmd); err != nil {
+			t.Fatalf("%q: %v", cmd, err)
+		}
+	}
+	hasText := func(text string) {
+		t.Helper()
+		if !strings.Contains(string(tweet.buffer), text) {
+			t.Errorf("%q not in %q", text, tweet.buffer)
+		}
+	}
+
+	run("like " + idStr)
+	if favorited, _ := tweet.data["favorited"].(bool); !favorited {
+		t.Error("not favorited")
+	}
+	hasText("likes 1")
+	if err := fs.runCtl(fs.root, "like "+idStr); err == nil {
+		t.Error("liked twice")
+	}
+	run("unlike " + idStr)
+	hasText("likes 0")
+
+	run("retweet " + idStr)
+	hasText("retweets 1")
+	me := fs.root.children["users"].children["janet"]
+	if me == nil || len(me.children) != 1 {
+		t.Fatal("retweet not in own directory")
+	}
+	var wrapper *node
+	for _, n := range me.children {
+		wrapper = n
+	}
+	run("unretweet " + idStr)
+	hasText("retweets 0")
+	if len(me.children) != 0 || wrapper.kind != orphanedKind || fs.tweets.get(wrapper.dir.Name) != nil {
+		t.Error("retweet not removed")
+	}
+
+	// Only our own tweets can be deleted.
+	if err := fs.runCtl(fs.root, "delete "+idStr); err == nil {
+		t.Error("deleted someone else's tweet")
+	}
+	if home.children[idStr] != tweet {
+		t.Error("tweet removed")
+	}
+	mine, err := fs.postTweet("Mine", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs.tweets.link(home, mine)
+	run("delete " + mine.dir.Name)
+	if mine.kind != orphanedKind || home.children[mine.dir.Name] != nil || me.children[mine.dir.Name] != nil {
+		t.Error("deleted tweet still listed")
+	}
+	if _, ok := fake.tweets[mine.dir.Name]; ok {
+		t.Error("tweet not deleted")
+	}
+
+	for _, call := range []string{
+		"POST /1.1/favorites/create.json",
+		"POST /1.1/favorites/destroy.json",
+		"POST /1.1/statuses/retweet/:id.json",
+		"POST /1.1/statuses/unretweet/:id.json",
+		"POST /1.1/statuses/destroy/:id.json",
+	} {
+		found := false
+		for _, c := range fake.calls {
+			found = found || c == call
+		}
+		if !found {
+			t.Errorf("%s not called", call)
+		}
+	}
+}
+
+func TestFollow(t *testing.T) {
+	fake := newFakeTwitter()
+	defer fake.close()
+	fake.following["john"] = true
+	fs, cleanup := newTestFileSystem(t, fake.client(), &fsConfig{ScreenName: "janet"})
+	defer cleanup()
+	users := fs.root.children["users"]
+	if err := fs.ensureLoaded(users); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if john := users.children["john"]; john == nil || !john.followed {
+		t.Fatal("john not followed")
+	}
+
+	mary, err := fs.follow("mary")
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if !mary.followed || users.children["mary"] != mary || !fake.following["mary"] {
+		t.Error("mary not followed")
+	}
+	if _, err := fs.follow("not a name"); err == nil {
+		t.Error("followed an invalid name")
+	}
+
+	// Walking to a user doesn't follow them, so they can't be
+	// unfollowed by removing their directory.
+	paul, perr := fs.walk1(users, "paul")
+	if perr != nil {
+		t.Fatal(perr)
+	}
+	if err := fs.unfollow(paul); err == nil {
+		t.Error("unfollowed a user not followed")
+	}
+	// Walking to them with other case gives the same directory.
+	if n, perr := fs.walk1(users, "Paul"); perr != nil || n != paul || users.children["paul"] != paul {
+		t.Errorf("walk to Paul: got %v, %v", n, perr)
+	}
+
+	if err := fs.unfollow(mary); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if users.children["mary"] != nil || mary.kind != orphanedKind || fake.following["mary"] {
+		t.Error("mary still followed")
+	}
+
+	// Reloading drops the users unfollowed elsewhere, but not the
+	// ones walked to.
+	delete(fake.following, "john")
+	if err := fs.runCtl(fs.root, "reload"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.ensureLoaded(users); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if users.children["john"] != nil {
+		t.Error("john still listed")
+	}
+	if users.children["paul"] != paul {
+		t.Error("paul not listed")
+	}
+}
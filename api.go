@@ -43,7 +43,7 @@ func apiUsersShow(client *twittergo.Client, screenName string) (twitterUser, err
 }
 
 func apiStatusesShow(client *twittergo.Client, idStr string) (twittergo.Tweet, error) {
-	const path = "https://api.twitter.com/1.1/statuses/show.json"
+	const path = "/1.1/statuses/show.json"
 	params := url.Values{}
 	params.Set("id", idStr)
 	request, err := http.NewRequest(http.MethodGet, path+"?"+params.Encode(), nil)
@@ -61,23 +61,106 @@ func apiStatusesShow(client *twittergo.Client, idStr string) (twittergo.Tweet, e
 	return tweet, nil
 }
 
-func apiStatusesUpdate(client *twittergo.Client, text string, inReply string) error {
-	const path = "https://api.twitter.com/1.1/statuses/update.json"
+func apiStatusesUpdate(client *twittergo.Client, text string, inReply string, quoteURL string, mediaIDs []string) (twittergo.Tweet, error) {
+	const path = "/1.1/statuses/update.json"
 	params := url.Values{}
 	params.Set("status", text)
+	params.Set("tweet_mode", "extended")
 	if inReply != "" {
 		params.Set("in_reply_to_status_id", inReply)
 		params.Set("auto_populate_reply_metadata", "true")
 	}
+	if quoteURL != "" {
+		params.Set("attachment_url", quoteURL)
+	}
+	if len(mediaIDs) > 0 {
+		params.Set("media_ids", strings.Join(mediaIDs, ","))
+	}
 	request, err := http.NewRequest(http.MethodPost, path+"?"+params.Encode(), nil)
 	if err != nil {
-		return errors.WithStack(err)
+		return nil, errors.WithStack(err)
 	}
-	_, err = client.SendRequest(request)
+	response, err := client.SendRequest(request)
 	if err != nil {
-		return errors.WithStack(err)
+		return nil, errors.WithStack(err)
 	}
-	return nil
+	var tweet twittergo.Tweet
+	if err := response.Parse(&tweet); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return tweet, nil
+}
+
+// Sends a request acting on a tweet, e.g., to like or retweet it,
+// and returns the tweet as it is after the action.
+func apiTweetAction(client *twittergo.Client, path string, idStr string) (twittergo.Tweet, error) {
+	params := url.Values{}
+	params.Set("id", idStr)
+	params.Set("tweet_mode", "extended")
+	request, err := http.NewRequest(http.MethodPost, path+"?"+params.Encode(), nil)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	response, err := client.SendRequest(request)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	var tweet twittergo.Tweet
+	if err := response.Parse(&tweet); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return tweet, nil
+}
+
+func apiFavoritesCreate(client *twittergo.Client, idStr string) (twittergo.Tweet, error) {
+	return apiTweetAction(client, "/1.1/favorites/create.json", idStr)
+}
+
+func apiFavoritesDestroy(client *twittergo.Client, idStr string) (twittergo.Tweet, error) {
+	return apiTweetAction(client, "/1.1/favorites/destroy.json", idStr)
+}
+
+// Returns the retweet, which wraps the tweet retweeted.
+func apiStatusesRetweet(client *twittergo.Client, idStr string) (twittergo.Tweet, error) {
+	return apiTweetAction(client, "/1.1/statuses/retweet/"+idStr+".json", idStr)
+}
+
+// Returns the tweet that was retweeted.
+func apiStatusesUnretweet(client *twittergo.Client, idStr string) (twittergo.Tweet, error) {
+	return apiTweetAction(client, "/1.1/statuses/unretweet/"+idStr+".json", idStr)
+}
+
+func apiStatusesDestroy(client *twittergo.Client, idStr string) (twittergo.Tweet, error) {
+	return apiTweetAction(client, "/1.1/statuses/destroy/"+idStr+".json", idStr)
+}
+
+// Sends a request about the relationship with a user, e.g., to follow
+// them, and returns the user.
+func apiFriendship(client *twittergo.Client, path string, screenName string) (twitterUser, error) {
+	params := url.Values{}
+	params.Set("screen_name", screenName)
+	request, err := http.NewRequest(http.MethodPost, path+"?"+params.Encode(), nil)
+	if err != nil {
+		return twitterUser{}, errors.WithStack(err)
+	}
+	response, err := client.SendRequest(request)
+	if err != nil {
+		return twitterUser{}, errors.WithStack(err)
+	}
+	var user twitterUser
+	if err := response.Parse(&user); err != nil {
+		return twitterUser{}, errors.WithStack(err)
+	}
+	user.ScreenName = strings.ToLower(user.ScreenName)
+	return user, nil
+}
+
+func apiFriendshipsCreate(client *twittergo.Client, screenName string) (twitterUser, error) {
+	return apiFriendship(client, "/1.1/friendships/create.json", screenName)
+}
+
+func apiFriendshipsDestroy(client *twittergo.Client, screenName string) (twitterUser, error) {
+	return apiFriendship(client, "/1.1/friendships/destroy.json", screenName)
 }
 
 func apiFriendsList(client *twittergo.Client) ([]twitterUser, error) {
@@ -115,7 +198,7 @@ more:
 }
 
 func apiStatusesHomeTimeline(client *twittergo.Client, batchSize int, sinceID string, maxID string) (twittergo.Timeline, error) {
-	const path = "https://api.twitter.com/1.1/statuses/home_timeline.json"
+	const path = "/1.1/statuses/home_timeline.json"
 	params := url.Values{}
 	params.Set("include_entities", "true")
 	params.Set("tweet_mode", "extended")
@@ -143,7 +226,7 @@ func apiStatusesHomeTimeline(client *twittergo.Client, batchSize int, sinceID st
 }
 
 func apiStatusesMentionsTimeline(client *twittergo.Client, batchSize int, sinceID string, maxID string) (twittergo.Timeline, error) {
-	const path = "https://api.twitter.com/1.1/statuses/mentions_timeline.json"
+	const path = "/1.1/statuses/mentions_timeline.json"
 	params := url.Values{}
 	params.Set("tweet_mode", "extended")
 	params.Set("include_entities", "true")
@@ -234,11 +317,42 @@ func tweetRelativePath(currentUser string, tweet twittergo.Tweet) string {
 	return ""
 }
 
-func retweetedRelativePath(currentUser string, tweet twittergo.Tweet) string {
+func quotedStatus(tweet twittergo.Tweet) twittergo.Tweet {
+	if val := tweet["quoted_status"]; val != nil {
+		if tweet, ok := val.(map[string]interface{}); ok {
+			return twittergo.Tweet(tweet)
+		}
+	}
+	return nil
+}
+
+func quotedRelativePath(currentUser string, tweet twittergo.Tweet) string {
+	if quoted := quotedStatus(tweet); quoted != nil {
+		return tweetRelativePath(currentUser, quoted)
+	}
+	// The quoted tweet may be unavailable, but the link to it is still there.
+	if val := tweet["quoted_status_permalink"]; val != nil {
+		if permalink, ok := val.(map[string]interface{}); ok {
+			if url, ok := permalink["expanded"].(string); ok {
+				return localizeURL(currentUser, url)
+			}
+		}
+	}
+	return ""
+}
+
+func retweetedStatus(tweet twittergo.Tweet) twittergo.Tweet {
 	if val := tweet["retweeted_status"]; val != nil {
 		if tweet, ok := val.(map[string]interface{}); ok {
-			return tweetRelativePath(currentUser, twittergo.Tweet(tweet))
+			return twittergo.Tweet(tweet)
 		}
 	}
+	return nil
+}
+
+func retweetedRelativePath(currentUser string, tweet twittergo.Tweet) string {
+	if retweeted := retweetedStatus(tweet); retweeted != nil {
+		return tweetRelativePath(currentUser, retweeted)
+	}
 	return ""
 }
@@ -6,6 +6,7 @@ import (
 	"os"
 	"os/user"
 	"path/filepath"
+	"time"
 
 	"github.com/pkg/errors"
 )
@@ -17,14 +18,56 @@ type fsConfig struct {
 	AccessTokenSecret string `json:"access_token_secret"`
 	ScreenName        string `json:"screen_name"`
 	ListenAddress     string `json:"listen_address"`
+
+	// Memory limits. Zero means no limit.
+	MaxTweets     int    `json:"max_tweets"`
+	MaxTweetBytes int    `json:"max_tweet_bytes"`
+	UserIdleTime  string `json:"user_idle_time"`
+
+	// List retweeted tweets once in the home timeline, rather than
+	// each retweet.
+	CollapseRetweets bool `json:"collapse_retweets"`
+
+	// Show counts of likes, retweets, etc., language, client, and more
+	// in tweet files.
+	Metadata bool `json:"metadata"`
+
+	// How to show times in tweets: IANA time zone name, e.g.,
+	// Europe/Rome, and a Go time layout, or "relative".
+	Timezone   string `json:"timezone"`
+	TimeFormat string `json:"time_format"`
+
+	// Wrap tweet text at this column, if positive.
+	WrapColumn int `json:"wrap_column"`
+
+	// How late scheduled posts can be sent, e.g., after the server
+	// was down. Later ones are moved to drafts. One hour if unset.
+	ScheduleGrace string `json:"schedule_grace"`
+
+	userIdleTime  time.Duration
+	scheduleGrace time.Duration
+
+	// Where the configuration file was found, also used for
+	// persistent state.
+	dir string
 }
 
-func loadDefaultConfig() (*fsConfig, error) {
+// Returns the directory holding the configuration and other
+// persistent state, $HOME/lib/twitterfs.
+func libDir() (string, error) {
 	cuser, err := user.Current()
 	if err != nil {
-		return nil, errors.WithStack(err)
+		return "", errors.WithStack(err)
 	}
-	f, err := os.Open(filepath.Join(cuser.HomeDir, "lib", "twitterfs", "config"))
+	return filepath.Join(cuser.HomeDir, "lib", "twitterfs"), nil
+}
+
+func loadDefaultConfig() (*fsConfig, error) {
+	dir, err := libDir()
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(filepath.Join(dir, "config"))
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
@@ -37,8 +80,19 @@ func loadDefaultConfig() (*fsConfig, error) {
 	if err := json.NewDecoder(f).Decode(&config); err != nil {
 		return nil, errors.WithStack(err)
 	}
+	config.dir = dir
 	if config.ListenAddress == "" {
 		config.ListenAddress = "localhost:7731"
 	}
+	if config.UserIdleTime != "" {
+		if config.userIdleTime, err = time.ParseDuration(config.UserIdleTime); err != nil {
+			return nil, errors.Wrapf(err, "user_idle_time %q", config.UserIdleTime)
+		}
+	}
+	if config.ScheduleGrace != "" {
+		if config.scheduleGrace, err = time.ParseDuration(config.ScheduleGrace); err != nil {
+			return nil, errors.Wrapf(err, "schedule_grace %q", config.ScheduleGrace)
+		}
+	}
 	return &config, nil
 }
@@ -0,0 +1,505 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/kurrik/twittergo"
+	"github.com/lionkov/go9p/p"
+	"github.com/pkg/errors"
+)
+
+// A command accepted by the control file. Writes to the control file
+// hold one command per line, a name followed by arguments separated by
+// blanks. Arguments containing blanks or newlines can be quoted with
+// single or double quotes, a quote being doubled to stand for itself,
+// as in rc:
+//
+//	post 'It''s a tweet'
+//
+// Quotes are special only at the start of an argument, so that the
+// text of tweets rarely needs quoting. Blank lines and lines starting
+// with # are ignored.
+//
+// Each timeline directory has its own control file, accepting the
+// commands that concern a timeline, without naming it.
+type ctlCommand struct {
+	name string
+	args string // Usage, e.g., "[id]", besides the target.
+	help string
+
+	// Whether the command is accepted by the root control file, and
+	// by the control files of timeline directories.
+	root, timeline bool
+
+	// In the root control file, the first argument is a timeline:
+	// home, mentions, or @user.
+	target bool
+
+	// Number of arguments, besides the target. With rest set, the
+	// last argument is the rest of the line, blanks included, unless
+	// quoted.
+	min, max int
+	rest     bool
+
+	// Runs the command with the target timeline, or the directory of
+	// the control file, if any, and the other arguments.
+	run func(fs *fsOps, dir *node, args []string) error
+}
+
+// A parsed command line, ready to run.
+type ctlCall struct {
+	line   int // For error messages.
+	cmd    *ctlCommand
+	target string
+	args   []string
+}
+
+var ctlCommands []*ctlCommand
+
+// The table refers to the methods running the commands, which refer
+// to the table to print help, hence the init function.
+func init() {
+	ctlCommands = []*ctlCommand{
+		{name: "post", args: "text", help: "post a tweet", root: true, min: 1, max: 1, rest: true, run: (*fsOps).ctlPost},
+		{name: "reply", args: "id text", help: "reply to the tweet with the given id", root: true, min: 2, max: 2, rest: true, run: (*fsOps).ctlReply},
+		{name: "thread", args: "text", help: "post tweets separated by --- lines, each a reply to the previous", root: true, min: 1, max: 1, rest: true, run: (*fsOps).ctlThread},
+		{name: "schedule", args: "time text", help: "post a tweet at the given time, in RFC 3339 format", root: true, min: 2, max: 2, rest: true, run: (*fsOps).ctlSchedule},
+		{name: "send", args: "name", help: "post the draft, then remove it", root: true, min: 1, max: 1, run: (*fsOps).ctlSend},
+		{name: "like", args: "id", help: "like the tweet", root: true, min: 1, max: 1, run: tweetCommand((*fsOps).like)},
+		{name: "unlike", args: "id", help: "undo liking the tweet", root: true, min: 1, max: 1, run: tweetCommand((*fsOps).unlike)},
+		{name: "retweet", args: "id", help: "retweet the tweet", root: true, min: 1, max: 1, run: tweetCommand((*fsOps).retweet)},
+		{name: "unretweet", args: "id", help: "undo retweeting the tweet", root: true, min: 1, max: 1, run: tweetCommand((*fsOps).unretweet)},
+		{name: "delete", args: "id", help: "delete one of your tweets", root: true, min: 1, max: 1, run: tweetCommand((*fsOps).deleteTweet)},
+		{name: "newer", help: "load newer tweets", root: true, timeline: true, target: true, run: (*fsOps).ctlNewer},
+		{name: "older", help: "load older tweets", root: true, timeline: true, target: true, run: (*fsOps).ctlOlder},
+		{name: "trim", args: "n", help: "keep only the n latest tweets", root: true, timeline: true, target: true, min: 1, max: 1, run: (*fsOps).ctlTrim},
+		{name: "markread", args: "[id]", help: "mark tweets up to id, or all loaded, as read", root: true, timeline: true, target: true, max: 1, run: (*fsOps).ctlMarkRead},
+		{name: "batch", args: "n", help: "load n tweets at a time", root: true, timeline: true, min: 1, max: 1, run: (*fsOps).ctlBatch},
+		{name: "metadata", args: "on|off", help: "show or hide metadata in tweet files", root: true, min: 1, max: 1, run: (*fsOps).ctlMetadata},
+		{name: "reload", args: "[templates]", help: "reload the followed users, or the tweet template", root: true, max: 1, run: (*fsOps).ctlReload},
+		{name: "reload", help: "forget the loaded tweets, to load the latest", timeline: true, run: (*fsOps).ctlReloadTimeline},
+	}
+}
+
+func (cmd *ctlCommand) accepted(timeline bool) bool {
+	return timeline && cmd.timeline || !timeline && cmd.root
+}
+
+func (cmd *ctlCommand) usage(timeline bool) string {
+	words := []string{cmd.name}
+	if cmd.target && !timeline {
+		words = append(words, "timeline")
+	}
+	if cmd.args != "" {
+		words = append(words, cmd.args)
+	}
+	return strings.Join(words, " ")
+}
+
+func lookupCtlCommand(name string, timeline bool) *ctlCommand {
+	for _, cmd := range ctlCommands {
+		if cmd.name == name && cmd.accepted(timeline) {
+			return cmd
+		}
+	}
+	return nil
+}
+
+// Describes the commands, one per line, for reading the root control
+// file, or that of a timeline directory.
+func ctlHelp(timeline bool) []byte {
+	var b bytes.Buffer
+	for _, cmd := range ctlCommands {
+		if cmd.accepted(timeline) {
+			_, _ = fmt.Fprintf(&b, "%-26s# %s\n", cmd.usage(timeline), cmd.help)
+		}
+	}
+	return b.Bytes()
+}
+
+type ctlSyntaxError struct {
+	line int
+	msg  string
+}
+
+func (e *ctlSyntaxError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.line, e.msg)
+}
+
+// Splits the commands written to the control file into command lines.
+type ctlScanner struct {
+	src  string
+	pos  int
+	line int
+}
+
+func (s *ctlScanner) errorf(format string, a ...interface{}) error {
+	return &ctlSyntaxError{line: s.line, msg: fmt.Sprintf(format, a...)}
+}
+
+func isBlank(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r'
+}
+
+func isQuote(c byte) bool {
+	return c == '\'' || c == '"'
+}
+
+func (s *ctlScanner) skipBlanks() {
+	for s.pos < len(s.src) && isBlank(s.src[s.pos]) {
+		s.pos++
+	}
+}
+
+func (s *ctlScanner) atEOL() bool {
+	return s.pos == len(s.src) || s.src[s.pos] == '\n'
+}
+
+// Skips the end of the current line, if any.
+func (s *ctlScanner) nextLine() {
+	if s.pos < len(s.src) {
+		s.pos++
+	}
+	s.line++
+}
+
+// Scans a quoted argument, which may span lines.
+func (s *ctlScanner) quoted() (string, error) {
+	quote := s.src[s.pos]
+	start := s.line
+	s.pos++
+	var b strings.Builder
+	for {
+		i := strings.IndexByte(s.src[s.pos:], quote)
+		if i == -1 {
+			return "", &ctlSyntaxError{line: start, msg: "unterminated quote"}
+		}
+		b.WriteString(s.src[s.pos : s.pos+i])
+		s.line += strings.Count(s.src[s.pos:s.pos+i], "\n")
+		s.pos += i + 1
+		if s.pos == len(s.src) || s.src[s.pos] != quote {
+			break
+		}
+		b.WriteByte(quote)
+		s.pos++
+	}
+	if !s.atEOL() && !isBlank(s.src[s.pos]) {
+		return "", s.errorf("unexpected %q after quote", s.src[s.pos])
+	}
+	return b.String(), nil
+}
+
+// Scans the next argument on the line, if any.
+func (s *ctlScanner) arg() (arg string, ok bool, err error) {
+	s.skipBlanks()
+	if s.atEOL() {
+		return "", false, nil
+	}
+	if isQuote(s.src[s.pos]) {
+		arg, err = s.quoted()
+		return arg, err == nil, err
+	}
+	start := s.pos
+	for !s.atEOL() && !isBlank(s.src[s.pos]) {
+		s.pos++
+	}
+	return s.src[start:s.pos], true, nil
+}
+
+// Scans the rest of the line as a single argument, blanks included,
+// unless quoted, i.e., starting with a quote whose closing quote ends
+// the line. Otherwise, e.g., for 'Tis the season, the text is taken as
+// it is, quotes included.
+func (s *ctlScanner) rest() (arg string, ok bool, err error) {
+	s.skipBlanks()
+	if s.atEOL() {
+		return "", false, nil
+	}
+	if isQuote(s.src[s.pos]) {
+		pos, line := s.pos, s.line
+		if arg, err = s.quoted(); err == nil {
+			s.skipBlanks()
+			if s.atEOL() {
+				return arg, true, nil
+			}
+		}
+		s.pos, s.line = pos, line
+	}
+	end := strings.IndexByte(s.src[s.pos:], '\n')
+	if end == -1 {
+		end = len(s.src) - s.pos
+	}
+	arg = strings.TrimRight(s.src[s.pos:s.pos+end], " \t\r")
+	s.pos += end
+	return arg, true, nil
+}
+
+// Parses all the commands in src, written to the root control file or
+// to that of a timeline directory, so that none is run if any is
+// malformed.
+func parseCtl(src string, timeline bool) ([]ctlCall, error) {
+	var calls []ctlCall
+	s := &ctlScanner{src: src, line: 1}
+	for ; s.pos < len(src); s.nextLine() {
+		s.skipBlanks()
+		if s.atEOL() || s.src[s.pos] == '#' {
+			for !s.atEOL() {
+				s.pos++
+			}
+			continue
+		}
+		call := ctlCall{line: s.line}
+		name, _, err := s.arg()
+		if err != nil {
+			return nil, err
+		}
+		call.cmd = lookupCtlCommand(name, timeline)
+		if call.cmd == nil {
+			return nil, s.errorf("%s %q", Eunknown.Err, name)
+		}
+		if call.cmd.target && !timeline {
+			target, ok, err := s.arg()
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				return nil, s.errorf("usage: %s", call.cmd.usage(timeline))
+			}
+			call.target = target
+		}
+		for len(call.args) < call.cmd.max {
+			var arg string
+			var ok bool
+			if call.cmd.rest && len(call.args) == call.cmd.max-1 {
+				arg, ok, err = s.rest()
+			} else {
+				arg, ok, err = s.arg()
+			}
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				break
+			}
+			call.args = append(call.args, arg)
+		}
+		s.skipBlanks()
+		if len(call.args) < call.cmd.min || !s.atEOL() {
+			return nil, s.errorf("usage: %s", call.cmd.usage(timeline))
+		}
+		calls = append(calls, call)
+	}
+	return calls, nil
+}
+
+// Runs the commands written to the control file in the given
+// directory, in order, stopping at the first that fails.
+func (fs *fsOps) runCtl(dir *node, src string) *p.Error {
+	if dir.kind == orphanedKind {
+		return Eorphaned
+	}
+	timeline := dir.kind != rootKind
+	calls, err := parseCtl(src, timeline)
+	if err != nil {
+		return &p.Error{Err: err.Error(), Errornum: p.EINVAL}
+	}
+	for _, call := range calls {
+		target := dir
+		if !timeline {
+			target = nil
+			if call.cmd.target {
+				if target, err = fs.resolveTimeline(call.target); err != nil {
+					return ctlRunError(call, err)
+				}
+			}
+		}
+		if err := call.cmd.run(fs, target, call.args); err != nil {
+			return ctlRunError(call, err)
+		}
+	}
+	return nil
+}
+
+func ctlRunError(call ctlCall, err error) *p.Error {
+	if perr, ok := err.(*p.Error); ok {
+		return &p.Error{
+			Err:      fmt.Sprintf("line %d: %s: %s", call.line, call.cmd.name, perr.Err),
+			Errornum: perr.Errornum,
+		}
+	}
+	// As with newEIO, make the stacktrace visible.
+	return &p.Error{
+		Err:      fmt.Sprintf("line %d: %s: %+v", call.line, call.cmd.name, err),
+		Errornum: p.EIO,
+	}
+}
+
+// Finds the timeline directory a command refers to: home, mentions,
+// or @user, walking to the user's directory if needed.
+func (fs *fsOps) resolveTimeline(name string) (*node, error) {
+	switch {
+	case name == "home", name == "mentions":
+		return fs.root.children[name], nil
+	case strings.HasPrefix(name, "@") && len(name) > 1:
+		dir, err := fs.walk1(fs.root.children["users"], strings.ToLower(name[1:]))
+		if err != nil {
+			return nil, err
+		}
+		if dir == nil {
+			return nil, errors.Errorf("%q: no such user", name)
+		}
+		// Using a user's timeline via the control file counts as
+		// accessing it, as for eviction of idle users.
+		fs.touch(dir)
+		return dir, nil
+	default:
+		return nil, errors.Errorf("%q: expected home, mentions, or @user", name)
+	}
+}
+
+// How many tweets to load at a time for the timeline.
+func (fs *fsOps) timelineBatchSize(n *node) int {
+	if n.batchSize > 0 {
+		return n.batchSize
+	}
+	return fs.batchSize
+}
+
+// Fetches a batch of the timeline's tweets, newer than sinceID and not
+// newer than maxID, if given. The lock is released for the call, so the
+// timeline may be orphaned by the time it returns.
+func (fs *fsOps) fetchTimeline(n *node, sinceID string, maxID string) (timeline twittergo.Timeline, err error) {
+	batchSize := fs.timelineBatchSize(n)
+	var fetch func() (twittergo.Timeline, error)
+	switch n.kind {
+	case homeKind:
+		fetch = func() (twittergo.Timeline, error) {
+			return apiStatusesHomeTimeline(fs.client, batchSize, sinceID, maxID)
+		}
+	case mentionsKind:
+		fetch = func() (twittergo.Timeline, error) {
+			return apiStatusesMentionsTimeline(fs.client, batchSize, sinceID, maxID)
+		}
+	case userKind:
+		screenName := n.dir.Name
+		fetch = func() (twittergo.Timeline, error) {
+			return apiStatusesUserTimeline(fs.client, screenName, batchSize, sinceID, maxID)
+		}
+	default:
+		return nil, errors.Errorf("%s: not a timeline", n.dir.Name)
+	}
+	fs.unlocked(func() {
+		timeline, err = fetch()
+	})
+	if err != nil {
+		return nil, err
+	}
+	if n.kind == orphanedKind {
+		return nil, errOrphaned
+	}
+	return timeline, nil
+}
+
+func (fs *fsOps) ctlPost(_ *node, args []string) error {
+	_, err := fs.postTweet(args[0], "")
+	return err
+}
+
+func (fs *fsOps) ctlReply(_ *node, args []string) error {
+	if !idStrExpr.MatchString(args[0]) {
+		return errors.Errorf("%q: not a tweet id", args[0])
+	}
+	_, err := fs.postTweet(args[1], args[0])
+	return err
+}
+
+func (fs *fsOps) ctlNewer(dir *node, _ []string) error {
+	timeline, err := fs.fetchTimeline(dir, dir.maxID, "")
+	if err != nil {
+		return err
+	}
+	dir.addTimeline(fs.tweets, fs.filters, timeline)
+	fs.evict()
+	return nil
+}
+
+func (fs *fsOps) ctlOlder(dir *node, _ []string) error {
+	timeline, err := fs.fetchTimeline(dir, "", dir.minID)
+	if err != nil {
+		return err
+	}
+	dir.addTimeline(fs.tweets, fs.filters, timeline)
+	fs.evict()
+	return nil
+}
+
+func (fs *fsOps) ctlTrim(dir *node, args []string) error {
+	size, err := strconv.Atoi(args[0])
+	if err != nil {
+		return errors.Errorf("%q: not a number", args[0])
+	}
+	if size < 0 {
+		return errors.Errorf("%q: can't trim to negative size", args[0])
+	}
+	dir.trim(fs.tweets, size)
+	return nil
+}
+
+func (fs *fsOps) ctlMarkRead(dir *node, args []string) error {
+	idStr := dir.maxID
+	if len(args) == 1 {
+		idStr = args[0]
+	}
+	if !idStrExpr.MatchString(idStr) {
+		return errors.Errorf("%q: not a tweet id", idStr)
+	}
+	fs.readState.setMarker(dir.timelineName(), idStr)
+	return fs.readState.save()
+}
+
+// Sets the batch size of the timeline, if any, or the default one.
+func (fs *fsOps) ctlBatch(dir *node, args []string) error {
+	size, err := strconv.Atoi(args[0])
+	if err != nil || size <= 0 {
+		return errors.Errorf("%q: not a positive number", args[0])
+	}
+	if dir != nil {
+		dir.batchSize = size
+	} else {
+		fs.batchSize = size
+	}
+	return nil
+}
+
+func (fs *fsOps) ctlMetadata(_ *node, args []string) error {
+	if args[0] != "on" && args[0] != "off" {
+		return errors.Errorf("%q: expected on or off", args[0])
+	}
+	fs.tweets.format.metadata = args[0] == "on"
+	fs.tweets.renderAll()
+	return nil
+}
+
+func (fs *fsOps) ctlReload(_ *node, args []string) error {
+	if len(args) == 0 {
+		fs.root.children["users"].loaded = false
+		return nil
+	}
+	if args[0] != "templates" {
+		return errors.Errorf("%q: expected templates", args[0])
+	}
+	if err := fs.tweets.format.load(); err != nil {
+		return err
+	}
+	fs.tweets.renderAll()
+	return nil
+}
+
+func (fs *fsOps) ctlReloadTimeline(dir *node, _ []string) error {
+	dir.trim(fs.tweets, 0)
+	dir.loaded = false
+	return nil
+}
@@ -0,0 +1,41 @@
+//go:build go1.18
+// +build go1.18
+
+package main
+
+import "testing"
+
+// Whatever is written to the control file, parsing doesn't crash, and
+// the parsed commands, with all arguments quoted, parse back the same.
+func FuzzParseCtl(f *testing.F) {
+	for _, seed := range []string{
+		"batch 5\nolder home\n",
+		"post It's fine",
+		"post 'It''s fine'\n# comment\n",
+		"reply 1274574891338129409 \"two\nlines\"",
+		"markread @janet 1274574891338129409\r\n",
+		"trim 'home'x 5",
+	} {
+		f.Add(seed, false)
+		f.Add(seed, true)
+	}
+	f.Fuzz(func(t *testing.T, src string, timeline bool) {
+		calls, err := parseCtl(src, timeline)
+		if err != nil {
+			return
+		}
+		for _, call := range calls {
+			if n := len(call.args); n < call.cmd.min || n > call.cmd.max {
+				t.Fatalf("%q: %s got %d arguments", src, call.cmd.name, n)
+			}
+		}
+		quoted := formatCtlCalls(calls, timeline)
+		again, err := parseCtl(quoted, timeline)
+		if err != nil {
+			t.Fatalf("%q: quoted as %q: %v", src, quoted, err)
+		}
+		if got := formatCtlCalls(again, timeline); got != quoted {
+			t.Fatalf("%q: quoted as %q, parsed back as %q", src, quoted, got)
+		}
+	})
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// Quotes arg so that it parses back as itself.
+func quoteCtlArg(arg string) string {
+	return "'" + strings.Replace(arg, "'", "''", -1) + "'"
+}
+
+// Formats the calls for comparison, one per line, arguments quoted.
+func formatCtlCalls(calls []ctlCall, timeline bool) string {
+	var lines []string
+	for _, call := range calls {
+		words := []string{call.cmd.name}
+		if call.cmd.target && !timeline {
+			words = append(words, quoteCtlArg(call.target))
+		}
+		for _, arg := range call.args {
+			words = append(words, quoteCtlArg(arg))
+		}
+		lines = append(lines, strings.Join(words, " "))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func TestParseCtl(t *testing.T) {
+	testCases := []struct {
+		src      string
+		timeline bool
+		want     string
+	}{
+		{"", false, ""},
+		{"\n\n  \n", false, ""},
+		{"# comment\nbatch 5", false, "batch '5'"},
+		{"batch 5\n", false, "batch '5'"},
+		{"batch 5\r\n", false, "batch '5'"},
+		{"batch 5\nolder home\n\nnewer @janet", false, "batch '5'\nolder 'home'\nnewer '@janet'"},
+		{"post hello,   world  \n", false, "post 'hello,   world'"},
+		{"post It's fine", false, "post 'It''s fine'"},
+		{"post 'It''s fine'", false, "post 'It''s fine'"},
+		{`post "two` + "\n" + `lines"`, false, "post 'two\nlines'"},
+		{`post ""`, false, "post ''"},
+		{`post "Great talk" by X`, false, `post '"Great talk" by X'`},
+		{"post 'Tis the season", false, "post '''Tis the season'"},
+		{"post 'unterminated\nbatch 5", false, "post '''unterminated'\nbatch '5'"},
+		{"reply 1274574891338129409 @janet indeed", false, "reply '1274574891338129409' '@janet indeed'"},
+		{"markread home", false, "markread 'home'"},
+		{"markread 'home' 1274574891338129409", false, "markread 'home' '1274574891338129409'"},
+		{"trim\t@janet\t10", false, "trim '@janet' '10'"},
+		{"reload\nreload templates", false, "reload\nreload 'templates'"},
+		{"newer\nolder\ntrim 5\nbatch 20\nmarkread\nreload", true, "newer\nolder\ntrim '5'\nbatch '20'\nmarkread\nreload"},
+	}
+	for _, tc := range testCases {
+		calls, err := parseCtl(tc.src, tc.timeline)
+		if err != nil {
+			t.Errorf("%q: %v", tc.src, err)
+			continue
+		}
+		if got := formatCtlCalls(calls, tc.timeline); got != tc.want {
+			t.Errorf("%q: got %q, want %q", tc.src, got, tc.want)
+		}
+	}
+	badCases := []struct {
+		src      string
+		timeline bool
+		want     string
+	}{
+		{"frobnicate", false, `line 1: unknown command "frobnicate"`},
+		{"batch 5\nfrobnicate", false, `line 2: unknown command "frobnicate"`},
+		{"batch", false, "line 1: usage: batch n"},
+		{"batch 5 6", false, "line 1: usage: batch n"},
+		{"older", false, "line 1: usage: older timeline"},
+		{"older home now", false, "line 1: usage: older timeline"},
+		{"post", false, "line 1: usage: post text"},
+		{"reply 1274574891338129409", false, "line 1: usage: reply id text"},
+		{"older 'home'x", false, `line 1: unexpected 'x' after quote`},
+		{"older 'home\nbatch 5", false, "line 1: unterminated quote"},
+		{"post 'two\nlines' x\nbatch", false, `line 2: unknown command "lines'"`},
+		{"post hello", true, `line 1: unknown command "post"`},
+		{"older home", true, "line 1: usage: older"},
+		{"reload templates", true, "line 1: usage: reload"},
+	}
+	for _, tc := range badCases {
+		_, err := parseCtl(tc.src, tc.timeline)
+		if err == nil {
+			t.Errorf("%q: no error", tc.src)
+		} else if got := err.Error(); got != tc.want {
+			t.Errorf("%q: got error %q, want %q", tc.src, got, tc.want)
+		}
+	}
+}
+
+func TestRunCtl(t *testing.T) {
+	fs, cleanup := newTestFileSystem(t, nil, &fsConfig{})
+	defer cleanup()
+	if err := fs.runCtl(fs.root, "batch 50\nmetadata on\n"); err != nil {
+		t.Fatal(err)
+	}
+	if fs.batchSize != 50 || !fs.tweets.format.metadata {
+		t.Errorf("got batch size %d and metadata %v", fs.batchSize, fs.tweets.format.metadata)
+	}
+	// Commands before a failing one are run, the ones after it aren't.
+	perr := fs.runCtl(fs.root, "batch 20\nbatch -1\nbatch 30")
+	if perr == nil || !strings.HasPrefix(perr.Err, "line 2: batch: ") {
+		t.Errorf("got error %v", perr)
+	}
+	if fs.batchSize != 20 {
+		t.Errorf("got batch size %d, want 20", fs.batchSize)
+	}
+	// Nothing is run if any command is malformed.
+	if err := fs.runCtl(fs.root, "batch 40\nmetadata"); err == nil {
+		t.Error("no error")
+	}
+	if fs.batchSize != 20 {
+		t.Errorf("got batch size %d, want 20", fs.batchSize)
+	}
+	if err := fs.runCtl(fs.root, "markread mentions 1274574891338129409"); err != nil {
+		t.Fatal(err)
+	}
+	if got := fs.readState.markers["mentions"]; got != "1274574891338129409" {
+		t.Errorf("got marker %q", got)
+	}
+	// A timeline's control file applies to that timeline.
+	home := fs.root.children["home"]
+	if err := fs.runCtl(home, "batch 5\nmarkread 1274574891338129410"); err != nil {
+		t.Fatal(err)
+	}
+	if home.batchSize != 5 || fs.batchSize != 20 {
+		t.Errorf("got batch sizes %d and %d", home.batchSize, fs.batchSize)
+	}
+	if got := fs.readState.markers["home"]; got != "1274574891338129410" {
+		t.Errorf("got marker %q", got)
+	}
+	for _, bad := range []string{
+		"markread home",
+		"markread nowhere 1274574891338129409",
+		"trim home -1",
+		"metadata maybe",
+		"reload everything",
+	} {
+		if err := fs.runCtl(fs.root, bad); err == nil {
+			t.Errorf("%q: no error", bad)
+		}
+	}
+}
@@ -1,5 +1,7 @@
 /*
-Command twitterfs is a read-only 9P interface to Twitter.
+Command twitterfs is a 9P interface to Twitter, to read timelines and
+to post, delete, like and retweet tweets, follow users, and keep drafts
+and scheduled posts.
 
 § 1. Configuration
 
@@ -12,7 +14,16 @@ Twitterfs is configured via $HOME/lib/twitterfs/config like so:
 		"access_token": "redacted",
 		"access_token_secret": "redacted",
 		"screen_name": "your_screen_name",
-		"listen_address": "localhost:7731"
+		"listen_address": "localhost:7731",
+		"max_tweets": 5000,
+		"max_tweet_bytes": 4000000,
+		"user_idle_time": "24h",
+		"collapse_retweets": false,
+		"metadata": false,
+		"timezone": "Europe/Rome",
+		"time_format": "2006-01-02 15:04",
+		"wrap_column": 72,
+		"schedule_grace": "1h"
 	}
 
 The keys/tokens/secrets can be obtained by creating a Twitter
@@ -21,6 +32,44 @@ application at https://developer.twitter.com/.
 The screen name is your screen name, used to fetch the list of
 followed users, to add to the root directory; see below.
 
+The settings max_tweets, max_tweet_bytes and user_idle_time bound
+memory usage, and are all optional. When more than max_tweets tweets
+are loaded, or their formatted text exceeds max_tweet_bytes bytes, the
+least recently accessed tweets are removed from all timelines.
+Directories of users that are not followed are removed after
+user_idle_time without being accessed, whether via the file system or
+the control file. Unset or zero values mean no limit.
+
+If collapse_retweets is true, a tweet retweeted by several followed
+users is listed in the home directory once, under its own id rather
+than the ids of the retweets. The tweet file lists who retweeted it
+and when, in Retweeted: lines.
+
+If metadata is true, tweet files end with a block of metadata: counts
+of retweets, likes, replies and quotes, language, client, and whether
+the tweet was edited or is possibly sensitive. Counts are updated
+whenever Twitter returns the tweet again. The block can be switched on
+and off with
+
+	echo metadata on >>ctl
+	echo metadata off >>ctl
+
+Times in tweet files are shown in UTC, in RFC 3339 format, unless
+timezone, an IANA time zone name, and time_format, a Go time layout,
+say otherwise. If time_format is "relative", times are shown like
+"3h ago", computed each time a tweet is read, and padded with spaces
+so that the size of tweet files stays the same. Modification times of
+files are not affected.
+
+If wrap_column is positive, the text of tweets is wrapped at that
+column, on the lines after the author and time. Wide characters, as
+in Chinese and Japanese, and emoji count as two columns. URLs and
+paths are never broken, even when longer than a line.
+
+Scheduled posts due while the server was down are sent when it
+starts, if at most schedule_grace late, one hour if unset, and moved
+to drafts otherwise; see § 2.
+
 § 2. File system structure and operation
 
 The server listens by default on 127.0.0.1:7731, also known as
@@ -37,17 +86,189 @@ Each user directory contains one file per tweet, named by its id.
 Upon first listing, the user directory will contain the latest 10
 tweets. Walking to a tweet file adds it to the file-system.
 
-It is not permitted to create or remove files or directories, nor to
-change their metadata such as their modification times or their
-names.
+A tweet is kept in memory only once, however many timelines list it:
+the same tweet seen in home, mentions and a user directory is the same
+file, with the same qid. Once fetched for any timeline, a tweet can be
+walked to from any timeline directory, in particular its author's,
+without fetching it again, although walking into a timeline not loaded
+yet loads it.
+
+The text of a quoted tweet is shown indented below the quoting tweet's
+text, and the quoted tweet's path is given in a Quotes: line. The
+quoted tweet is kept as if it had been fetched, so that the path
+resolves without fetching it again. It isn't listed in its author's
+directory, though, and walking into that directory loads the author's
+timeline, if not loaded yet.
+
+Tweet files are formatted with a text/template. The built-in one can
+be replaced by a template in $HOME/lib/twitterfs/tweet.tmpl, which is
+executed with a value having fields and methods such as .ScreenName,
+.Name, .ID, .Time, .CreatedAt, .Text, .RawText, .Path, .Parent,
+.Retweets, .Quotes, .Quoted, .Retweeted, .Links, .Hashtags, .Mentions,
+.RetweetedBy, .Localize, .Counts, .RetweetCount, .LikeCount,
+.ReplyCount, .QuoteCount, .Lang, .Source, .Edited and .Sensitive, and
+the raw tweet as .Tweet, e.g., {{.Tweet.lang}}. Paths are relative to
+the author's directory. In .Text, t.co links are replaced by the URLs
+they stand for, or by relative paths for links to tweets, links to
+attached media are removed, and HTML entities are decoded; .RawText is
+the text as returned by Twitter. Functions formatTime, indent, lower,
+join, wrap and wrapping are available. See the source for the built-in
+template. After changing the template, apply it to all tweets with
+
+	echo reload templates >>ctl
+
+A tweet with photos, GIFs or videos is listed together with a media
+directory, e.g., 1234.media next to 1234. It contains one file per
+photo and one per video variant, named by position and, for videos,
+bitrate, e.g., 1.jpg, 2.jpg, 3-832000.mp4. Listing the directory
+finds out the sizes of the files; their contents are downloaded on
+first read and kept with the tweet. The index file lists each media
+file's name, content type, size and alt text, separated by tabs.
+
+Most files are read-only. The exceptions, described below, are these:
+
+  - Control files, the post and thread files, drafts, and the filters
+    file (see § 3) can be written.
+  - Drafts can be created and removed, renamed, truncated or extended,
+    and their modification times changed.
+  - Files can be created in the outbox, which posts them.
+  - Directories can be created in the users directory, which follows
+    the users, and those of followed users removed, which unfollows
+    them.
+  - Your tweets can be removed, which deletes them.
+  - Scheduled posts can be removed, which cancels them.
+
+Drafts can be at most 512MB, the largest media Twitter accepts.
+
+The post file works like Plan 9 clone files: each open gets its own
+copy, the text written to it is posted as a tweet, and reading it
+back gives the path of the new tweet, already listed in the user's
+directory:
+
+	; {echo Hello, world >[1=0]; cat} <>/n/twitter/post
+	/users/janet/1274574891338129409
+
+If the text isn't read back, it's posted when the file is closed, so
+that echo Hello, world >/n/twitter/post also works. Errors can't be
+returned on close, so if posting fails then, the text is kept in the
+drafts directory, named like unsent-2020-06-21T08:00:00Z.
+
+The thread file works the same, but posts a thread: the text written
+is split into tweets at lines containing only ---, and each tweet is
+posted as a reply to the previous one. Reading the file back gives the
+paths of the tweets, one per line. If posting fails midway, the error
+lists the ids of the tweets posted, and reading the file again resumes
+posting where it stopped. A thread can also continue an existing one,
+if its first line is reply and the id of the tweet to reply to:
+
+	; cat thread.txt
+	reply 1274574891338129409
+	First tweet of the continuation.
+	---
+	Second.
+	; {cat thread.txt >[1=0]; cat} <>/n/twitter/thread
+
+The thread command of the control file takes the same text, usually
+quoted, as it spans lines.
+
+Photos, GIFs and videos are attached by starting the text of a file
+to post with a header of Media: lines, giving paths of files,
+absolute or relative to the drafts directory (see below), each
+possibly followed by an Alt: line giving its alt text, and a blank
+line. The header can also give the tweet replied to, in a Reply-To:
+line, and the tweet quoted, in a Quote: line, by id or path:
+
+	; cat tweet.txt
+	Reply-To: /users/john/1274574891338129409
+	Media: /usr/janet/cat.jpg
+	Alt: A cat asleep on a keyboard
+
+	Look who's helping.
+	; cat tweet.txt >/n/twitter/post
+
+Photos are uploaded in one request, GIFs, videos and photos larger
+than 5MB in chunks of 1MB, waiting for Twitter to process them before
+posting. If any upload fails, nothing is posted. The header works in
+files: the post and thread files, drafts, and the outbox. Lines are
+taken for a header only if they all are header lines and a blank line
+follows them; a file starting with a blank line has no header. Text
+given to commands of the control file has no header, and is posted as
+it is.
+
+The drafts directory holds drafts of tweets, which can be created,
+edited with any editor, renamed and removed, and are saved to
+$HOME/lib/twitterfs/drafts as they're written. A draft is the text of
+a tweet, possibly with a header as above. Media files can be copied
+to the drafts directory too, and attached by name. A draft is posted,
+then removed, with
+
+	echo send hello >>ctl
+
+or by moving it to the outbox directory, which is always empty: files
+created there are posted like those written to the post file, and an
+empty one posts the draft of the same name. As 9P can't move files
+between directories, mv copies the draft, which works as well, then
+removes it:
+
+	; cp /usr/janet/cat.jpg /n/twitter/drafts
+	; cat >/n/twitter/drafts/hello
+	Media: cat.jpg
 
-The file-system is read-only, except control files, which are
-write-only.
+	Hello, world
+	; mv /n/twitter/drafts/hello /n/twitter/outbox
 
-To load more tweets for a user,
+Tweets can be scheduled, to be posted at a given time, in RFC 3339
+format:
 
-    echo newer user >>ctl
-    echo older user >>ctl
+	echo schedule 2020-06-21T10:00:00+02:00 Good morning >>ctl
+
+The text is posted as it is. Scheduled posts are listed in
+the scheduled directory, named by the time they're due, in UTC, with
+a suffix like .1 if several are due at the same time; removing one
+cancels it. They're saved to $HOME/lib/twitterfs/scheduled, so that
+they survive restarts. Posts due while the server was down are sent
+when it starts, unless more than schedule_grace late; those are moved
+to drafts instead, named like missed-2020-06-21T08:00:00Z, so that
+nothing is posted out of context, nor lost. Posts failing to send are
+retried every minute, until they're as late, then moved to drafts
+too.
+
+Tweets can be liked, retweeted, and deleted if they're yours, and
+likes and retweets undone, by id:
+
+	echo like 1274574891338129409 >>ctl
+	echo unlike 1274574891338129409 >>ctl
+	echo retweet 1274574891338129409 >>ctl
+	echo unretweet 1274574891338129409 >>ctl
+	echo delete 1274574891338129409 >>ctl
+
+Counts in tweet files are updated accordingly, retweets are listed in
+your directory, and deleted tweets are removed from all directories.
+Removing one of your tweet files, as per screen_name, also deletes the
+tweet.
+
+Commands are written to the control file one per line, and several
+can be written at once. Each is a name followed by arguments separated
+by blanks; an argument containing blanks or newlines can be quoted
+with single or double quotes, doubling a quote to stand for itself, as
+in rc. Quotes are special only at the start of an argument, and the
+text of a post or reply is the rest of the line, quoted only if it
+ends with the closing quote, so that it rarely needs quoting:
+
+	echo post Hello, world >>ctl
+	echo 'reply 1274574891338129409 ''two
+	lines''' >>ctl
+
+Lines starting with # are ignored. If any command is malformed, none
+is run; otherwise commands are run in order until one fails, and the
+error names the failing line. Reading the control file lists the
+commands.
+
+Commands taking a timeline accept home, mentions, or @user. To load
+more tweets for a user,
+
+    echo newer @user >>ctl
+    echo older @user >>ctl
 
 The tweets batch size is 10 by default. The command
 
@@ -55,10 +276,89 @@ The tweets batch size is 10 by default. The command
 
 will change the batch size to 50.
 
-To reload the root directory (the list of followed users), use
+Each timeline directory (home, mentions, and user directories) also
+has a control file, accepting the commands about a timeline without
+naming it: newer, older, trim n, markread [id], batch n, which sets
+the batch size for that timeline only, and reload, which forgets the
+loaded tweets so that the latest are loaded on next access:
+
+	; cd /n/twitter/users/janet
+	; echo older >ctl
+
+The status file in the root directory shows the current settings,
+such as screen_name, listen_address and batch, how many tweets and
+users are loaded, and how many drafts and scheduled posts there are,
+one per line, as a key, a blank, and a value. The
+status file of a timeline directory shows its batch size, whether it's
+loaded, how many tweets it lists and how many are unread, the ids of
+the oldest and newest (min_id and max_id) and its read marker:
+
+	; awk '$1 == "max_id" { print $2 }' /n/twitter/home/status
+
+To reload the list of followed users in the users directory, use
 
     echo reload >>ctl
 
-This will not remove unfollowed users, only add new followed users.
+This adds users followed since, and removes the directories of users
+unfollowed since, e.g., on the web, but not those of users walked to.
+
+Making a directory in the users directory follows the user, and
+removing the directory of a followed user unfollows them:
+
+	; mkdir /n/twitter/users/janet
+	; rm /n/twitter/users/janet
+
+Directories of users not followed can't be removed.
+
+Each timeline directory also has a file named feed, containing all
+the loaded tweets of the timeline, newest first, each preceded by a
+separator line of dashes. One read gives the whole timeline.
+
+Similarly, the mbox file contains the timeline as a mailbox, oldest
+tweet first, with one message per tweet. The sender is the author,
+e.g., janet@twitter.com, the message id is made from the tweet id,
+and replies refer to the tweet replied to, so that mail readers can
+thread conversations:
+
+	; mutt -f /n/twitter/home/mbox
+
+Each timeline directory (home, mentions, and user directories) has an
+unread subdirectory, listing the tweets of the timeline that haven't
+been read. A tweet is read once its file has been read to the end, or
+once the timeline's read marker is moved past it:
+
+	echo markread home >>ctl
+	echo markread @janet 1274574891338129409 >>ctl
+
+The first command marks all loaded tweets in the home timeline as
+read; the second marks as read all tweets in @janet's timeline up to
+the given one, and as unread the later ones not read individually.
+Read state is saved to $HOME/lib/twitterfs/read, a few seconds after
+it changes. Tweets read individually stay read when their timeline is
+trimmed or reloaded, but only the newest 10000 are remembered; the
+markers don't expire.
+
+§ 3. Filters
+
+The root directory also contains a file named filters, holding rules
+that hide tweets from timeline listings, one per line:
+
+	mute user @janet
+	mute word /regexp/
+	mute word some text
+	hide retweets [from @janet] [in home|mentions|@john]
+	hide replies [from @janet] [in home|mentions|@john]
+
+Append a rule to add it, or write "remove" followed by a rule to
+remove it, or "clear" to remove them all:
+
+	echo hide retweets in home >>filters
+	echo remove hide retweets in home >>filters
+
+Reading the file shows the rules, each followed by the number of
+tweets it hid. The file can also be edited and written back whole.
+Lines written take effect once complete, and a write with a bad line
+is refused whole. Rules are saved to $HOME/lib/twitterfs/filters. Hidden tweets are
+not listed, but can still be walked to by id.
 */
 package main
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lionkov/go9p/p"
+	"github.com/lionkov/go9p/p/srv"
+	"github.com/pkg/errors"
+)
+
+// Drafts are files in /drafts, edited with any editor, and saved to
+// $HOME/lib/twitterfs/drafts as they're written, so that they survive
+// restarts. A draft is the text of a tweet, possibly with a header
+// (see outgoing). Media to attach can be written to /drafts too, and
+// referred to by relative paths.
+
+var (
+	Ebadname = &p.Error{Err: "bad file name", Errornum: p.EINVAL}
+	Etoobig  = &p.Error{Err: "file too large", Errornum: p.EINVAL}
+)
+
+// Drafts are kept in memory, so their size is limited, to that of the
+// largest media Twitter accepts, videos of 512MB.
+const maxDraftSize = 512 << 20
+
+func (fs *fsOps) draftsDir() string {
+	return filepath.Join(fs.config.dir, "drafts")
+}
+
+func validDraftName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsAny(name, "/\x00")
+}
+
+// Adds the drafts saved to disk to the drafts directory.
+func (fs *fsOps) loadDrafts(dir *node) error {
+	if err := os.MkdirAll(fs.draftsDir(), 0700); err != nil {
+		return errors.WithStack(err)
+	}
+	infos, err := ioutil.ReadDir(fs.draftsDir())
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	for _, info := range infos {
+		if !info.Mode().IsRegular() || !validDraftName(info.Name()) {
+			continue
+		}
+		b, err := ioutil.ReadFile(filepath.Join(fs.draftsDir(), info.Name()))
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		draft := dir.addChild(info.Name(), 0664, draftKind)
+		draft.buffer = b
+		draft.dir.Length = uint64(len(b))
+		draft.dir.Mtime = uint32(info.ModTime().Unix())
+		draft.dir.Atime = draft.dir.Mtime
+	}
+	dir.prepareDirEntries()
+	dir.loaded = true
+	return nil
+}
+
+// Updates the directory entry of the draft after a change.
+func (fs *fsOps) updateDraftEntry(draft *node) {
+	draft.dir.Length = uint64(len(draft.buffer))
+	draft.dir.Mtime = uint32(time.Now().Unix())
+	draft.parent.prepareDirEntries()
+}
+
+// Saves the draft to disk, whole, and updates its directory entry. For
+// empty drafts, as writes save only what they change.
+func (fs *fsOps) saveDraft(draft *node) error {
+	fs.updateDraftEntry(draft)
+	draft.fileMu.Lock()
+	defer draft.fileMu.Unlock()
+	return errors.WithStack(ioutil.WriteFile(filepath.Join(fs.draftsDir(), draft.dir.Name), draft.buffer, 0600))
+}
+
+func (fs *fsOps) createDraft(dir *node, name string) (*node, *p.Error) {
+	if !validDraftName(name) {
+		return nil, Ebadname
+	}
+	if _, ok := dir.children[name]; ok {
+		return nil, srv.Eexist
+	}
+	draft := dir.addChild(name, 0664, draftKind)
+	if err := fs.saveDraft(draft); err != nil {
+		delete(dir.children, name)
+		dir.prepareDirEntries()
+		return nil, newEIO(err)
+	}
+	draft.dir.Atime = draft.dir.Mtime
+	return draft, nil
+}
+
+// Saves the text as a new draft, named base, or base with a suffix
+// like .1 if taken, e.g., for posts that couldn't be sent.
+func (fs *fsOps) saveAsDraft(base string, text []byte) (*node, error) {
+	drafts := fs.root.children["drafts"]
+	name := base
+	for i := 1; drafts.children[name] != nil; i++ {
+		name = base + "." + strconv.Itoa(i)
+	}
+	draft, perr := fs.createDraft(drafts, name)
+	if perr != nil {
+		return nil, errors.New(perr.Err)
+	}
+	if err := fs.writeDraftAt(draft, 0, text); err != nil {
+		return nil, err
+	}
+	return draft, nil
+}
+
+func (fs *fsOps) writeDraft(r *srv.Req) {
+	if r.Fid.Aux.(*node).sending {
+		respondError(r, Esending)
+		return
+	}
+	if r.Tc.Offset > maxDraftSize || r.Tc.Offset+uint64(r.Tc.Count) > maxDraftSize {
+		respondError(r, Etoobig)
+		return
+	}
+	if err := fs.writeDraftAt(r.Fid.Aux.(*node), int(r.Tc.Offset), r.Tc.Data[:r.Tc.Count]); err != nil {
+		respondError(r, newEIO(err))
+		return
+	}
+	r.RespondRwrite(r.Tc.Count)
+}
+
+// Writes over the draft at the given offset, extending it as needed.
+// Writes past the end append. Only the range written is written to
+// disk, without the lock held, so that copying large media into the
+// drafts directory holds up neither the copy nor other requests.
+func (fs *fsOps) writeDraftAt(draft *node, offset int, data []byte) error {
+	if offset > len(draft.buffer) {
+		offset = len(draft.buffer)
+	}
+	if end := offset + len(data); end > len(draft.buffer) {
+		draft.buffer = append(draft.buffer, make([]byte, end-len(draft.buffer))...)
+	}
+	copy(draft.buffer[offset:], data)
+	draft.fileMu.Lock()
+	f, err := os.OpenFile(filepath.Join(fs.draftsDir(), draft.dir.Name), os.O_WRONLY|os.O_CREATE, 0600)
+	if err != nil {
+		draft.fileMu.Unlock()
+		return errors.WithStack(err)
+	}
+	fs.updateDraftEntry(draft)
+	fs.unlocked(func() {
+		defer draft.fileMu.Unlock()
+		_, err = f.WriteAt(data, int64(offset))
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+	})
+	return errors.WithStack(err)
+}
+
+func (fs *fsOps) truncateDraft(draft *node) error {
+	draft.buffer = nil
+	return fs.saveDraft(draft)
+}
+
+func (fs *fsOps) removeDraft(draft *node) error {
+	if draft.sending {
+		return Esending
+	}
+	if err := os.Remove(filepath.Join(fs.draftsDir(), draft.dir.Name)); err != nil && !os.IsNotExist(err) {
+		return errors.WithStack(err)
+	}
+	delete(draft.parent.children, draft.dir.Name)
+	draft.parent.prepareDirEntries()
+	draft.kind = orphanedKind
+	return nil
+}
+
+// Renames the draft, and changes its length or modification time.
+// Other changes aren't permitted, except to the values the draft has
+// already, and are refused before anything is changed.
+func (fs *fsOps) wstatDraft(draft *node, d *p.Dir) *p.Error {
+	const (
+		keep32 = ^uint32(0)
+		keep64 = ^uint64(0)
+	)
+	if d.Mode != keep32 && d.Mode != draft.dir.Mode ||
+		d.Uid != "" && d.Uid != draft.dir.Uid ||
+		d.Gid != "" && d.Gid != draft.dir.Gid {
+		return Eperm
+	}
+	if draft.sending {
+		return Esending
+	}
+	if d.Length != keep64 && d.Length > maxDraftSize {
+		return Etoobig
+	}
+	rename := d.Name != "" && d.Name != draft.dir.Name
+	if rename {
+		if !validDraftName(d.Name) {
+			return Ebadname
+		}
+		if _, ok := draft.parent.children[d.Name]; ok {
+			return srv.Eexist
+		}
+		oldpath := filepath.Join(fs.draftsDir(), draft.dir.Name)
+		if err := os.Rename(oldpath, filepath.Join(fs.draftsDir(), d.Name)); err != nil {
+			return newEIO(errors.WithStack(err))
+		}
+		delete(draft.parent.children, draft.dir.Name)
+		draft.dir.Name = d.Name
+		draft.parent.children[d.Name] = draft
+	}
+	if d.Length != keep64 && d.Length != draft.dir.Length {
+		if d.Length < uint64(len(draft.buffer)) {
+			draft.buffer = draft.buffer[:d.Length]
+		} else {
+			draft.buffer = append(draft.buffer, make([]byte, int(d.Length)-len(draft.buffer))...)
+		}
+		draft.fileMu.Lock()
+		err := os.Truncate(filepath.Join(fs.draftsDir(), draft.dir.Name), int64(d.Length))
+		draft.fileMu.Unlock()
+		if err != nil {
+			return newEIO(errors.WithStack(err))
+		}
+		fs.updateDraftEntry(draft)
+	}
+	if d.Mtime != keep32 {
+		draft.dir.Mtime = d.Mtime
+		path := filepath.Join(fs.draftsDir(), draft.dir.Name)
+		mtime := time.Unix(int64(d.Mtime), 0)
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			log.Printf("Could not set modification time of %q: %v", path, err)
+		}
+	}
+	draft.parent.prepareDirEntries()
+	return nil
+}
+
+// Posts the draft, then removes it. Media referred to by the draft are
+// kept, for other drafts to use.
+func (fs *fsOps) sendDraft(name string) (*node, error) {
+	draft, ok := fs.root.children["drafts"].children[name]
+	if !ok {
+		return nil, errors.Errorf("%q: no such draft", name)
+	}
+	var tweet *node
+	err := sendOnce(draft, func() error {
+		var err error
+		tweet, err = fs.postFile(string(draft.buffer))
+		return err
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "%s", name)
+	}
+	if err := fs.removeDraft(draft); err != nil {
+		log.Printf("Could not remove draft %q, already posted as %s: %+v", name, tweet.dir.Name, err)
+	}
+	return tweet, nil
+}
+
+func (fs *fsOps) ctlSend(_ *node, args []string) error {
+	_, err := fs.sendDraft(args[0])
+	return err
+}
+
+// Files created in the outbox are posted on read or clunk, like
+// clones of the post file. If nothing is written, the draft of the
+// same name is posted, so that moving a draft to the outbox, which
+// over 9P means copying it and removing the original, or creating an
+// empty file, sends it.
+func (fs *fsOps) createOutgoing(dir *node, name string) (*node, *p.Error) {
+	if !validDraftName(name) {
+		return nil, Ebadname
+	}
+	n := (*node)(nil).addChild(name, 0664, postKind)
+	n.parent = dir
+	n.dir.Mtime = uint32(time.Now().Unix())
+	n.dir.Atime = n.dir.Mtime
+	return n, nil
+}
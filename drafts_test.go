@@ -0,0 +1,231 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/lionkov/go9p/p"
+	"github.com/lionkov/go9p/p/srv"
+)
+
+// A stat(9p) structure changing nothing but what's set by the caller.
+func wstatNothing() p.Dir {
+	return p.Dir{
+		Mode:   ^uint32(0),
+		Atime:  ^uint32(0),
+		Mtime:  ^uint32(0),
+		Length: ^uint64(0),
+	}
+}
+
+func TestDrafts(t *testing.T) {
+	fake := newFakeTwitter()
+	defer fake.close()
+	quoted := fake.addTweet("john", "Quote me")
+	fs, cleanup := newTestFileSystem(t, fake.client(), &fsConfig{})
+	defer cleanup()
+	drafts := fs.root.children["drafts"]
+
+	hello, perr := fs.createDraft(drafts, "hello")
+	if perr != nil {
+		t.Fatal(perr)
+	}
+	if _, perr := fs.createDraft(drafts, "hello"); perr != srv.Eexist {
+		t.Errorf("got %v creating a draft twice", perr)
+	}
+	if _, perr := fs.createDraft(drafts, "a/b"); perr != Ebadname {
+		t.Errorf("got %v creating a/b", perr)
+	}
+	if err := fs.writeDraftAt(hello, 0, []byte("Quote: "+quoted.IdStr()+"\n\nHello, wordl\n")); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if err := fs.writeDraftAt(hello, len("Quote: "+quoted.IdStr()+"\n\nHello, wor"), []byte("ld")); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	want := "Quote: " + quoted.IdStr() + "\n\nHello, world\n"
+	if b, err := ioutil.ReadFile(filepath.Join(fs.draftsDir(), "hello")); err != nil || string(b) != want {
+		t.Errorf("got %q, %v on disk", b, err)
+	}
+
+	// Drafts are renamed via wstat, and truncated or extended.
+	d := wstatNothing()
+	d.Name = "greeting"
+	if perr := fs.wstatDraft(hello, &d); perr != nil {
+		t.Fatal(perr)
+	}
+	if drafts.children["greeting"] != hello || drafts.children["hello"] != nil {
+		t.Error("draft not renamed")
+	}
+	if _, err := os.Stat(filepath.Join(fs.draftsDir(), "greeting")); err != nil {
+		t.Error(err)
+	}
+	d = wstatNothing()
+	d.Mode = 0600
+	if perr := fs.wstatDraft(hello, &d); perr != Eperm {
+		t.Errorf("got %v changing the mode", perr)
+	}
+	other, _ := fs.createDraft(drafts, "other")
+	d = wstatNothing()
+	d.Name = "greeting"
+	if perr := fs.wstatDraft(other, &d); perr != srv.Eexist {
+		t.Errorf("got %v renaming over another draft", perr)
+	}
+	d = wstatNothing()
+	d.Length = 3
+	if perr := fs.wstatDraft(other, &d); perr != nil || string(other.buffer) != "\x00\x00\x00" {
+		t.Errorf("got %q, %v extending", other.buffer, perr)
+	}
+	// Up to a limit, refused before anything changes.
+	d.Name = "too-big"
+	for _, length := range []uint64{maxDraftSize + 1, 1 << 63} {
+		d.Length = length
+		if perr := fs.wstatDraft(other, &d); perr != Etoobig || other.dir.Name != "other" || len(other.buffer) != 3 {
+			t.Errorf("got %v, %q, %d bytes extending to %d", perr, other.dir.Name, len(other.buffer), length)
+		}
+	}
+	if err := fs.removeDraft(other); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(fs.draftsDir(), "other")); !os.IsNotExist(err) {
+		t.Errorf("removed draft still on disk: %v", err)
+	}
+
+	// Drafts survive restarts.
+	photoData := append([]byte("\x89PNG\r\n\x1a\n"), make([]byte, 100)...)
+	if err := ioutil.WriteFile(filepath.Join(fs.draftsDir(), "cat.png"), photoData, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(fs.draftsDir(), "photo"), []byte("Media: cat.png\nAlt: A cat\n\nMeow"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	fs, err := newFileSystemOps(fake.client(), fs.config)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	fs.mu.Lock()
+	drafts = fs.root.children["drafts"]
+	var names []string
+	for name := range drafts.children {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if got := strings.Join(names, " "); got != "cat.png greeting photo" {
+		t.Errorf("got drafts %q", got)
+	}
+	if got := string(drafts.children["greeting"].buffer); got != want {
+		t.Errorf("got %q after restart", got)
+	}
+
+	// Sending posts the draft, then removes it, keeping the media.
+	if err := fs.runCtl(fs.root, "send greeting\nsend photo"); err != nil {
+		t.Fatal(err)
+	}
+	if len(drafts.children) != 1 || drafts.children["cat.png"] == nil {
+		t.Errorf("got %d drafts after sending", len(drafts.children))
+	}
+	var sawQuote, sawPhoto bool
+	for _, tweet := range fake.tweets {
+		switch tweet.FullText() {
+		case "Hello, world":
+			sawQuote = tweet["quoted_status_id_str"] == quoted.IdStr()
+		case "Meow":
+			media := tweet.ExtendedEntities().Media()
+			sawPhoto = len(media) == 1 && fake.uploads[media[0]["id_str"].(string)].alt == "A cat"
+		}
+	}
+	if !sawQuote || !sawPhoto {
+		t.Errorf("quote posted: %v, photo posted: %v", sawQuote, sawPhoto)
+	}
+	if err := fs.runCtl(fs.root, "send greeting"); err == nil {
+		t.Error("sent a draft twice")
+	}
+
+	// Files created in the outbox are posted, or the draft of the
+	// same name if they're empty.
+	outbox := fs.root.children["outbox"]
+	reply, _ := fs.createDraft(drafts, "reply")
+	if err := fs.writeDraftAt(reply, 0, []byte("Reply-To: /users/john/"+quoted.IdStr()+"\n\nIndeed")); err != nil {
+		t.Fatal(err)
+	}
+	n, perr := fs.createOutgoing(outbox, "reply")
+	if perr != nil {
+		t.Fatal(perr)
+	}
+	if err := fs.ensureLoaded(n); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	idStr := strings.TrimPrefix(strings.TrimSpace(string(n.buffer)), "/users/janet/")
+	if got, _ := get(fake.tweets[idStr], "in_reply_to_status_id_str"); got != quoted.IdStr() || drafts.children["reply"] != nil {
+		t.Errorf("draft sent via the outbox replied to %q", got)
+	}
+	n, _ = fs.createOutgoing(outbox, "copy")
+	n.buffer = []byte("Copied in")
+	if err := fs.ensureLoaded(n); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	n, _ = fs.createOutgoing(outbox, "missing")
+	if err := fs.ensureLoaded(n); err == nil {
+		t.Error("posted a missing draft")
+	}
+	if len(outbox.children) != 0 {
+		t.Errorf("outbox not empty")
+	}
+}
+
+func TestWriteDraftBeyondLimit(t *testing.T) {
+	fs, cleanup := newTestFileSystem(t, nil, &fsConfig{})
+	defer cleanup()
+	c := mount(t, fs)
+	defer c.Unmount()
+	f, err := c.FCreate("/drafts/big", 0664, p.OWRITE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	for _, offset := range []int64{maxDraftSize, 1 << 62, -1 << 63} {
+		if _, err := f.WriteAt([]byte("x"), offset); err == nil {
+			t.Errorf("wrote at %d", offset)
+		}
+	}
+	if _, err := f.WriteAt([]byte("fits"), 0); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestWriteDraftInPlace(t *testing.T) {
+	fs, cleanup := newTestFileSystem(t, nil, &fsConfig{})
+	defer cleanup()
+	path := filepath.Join(fs.draftsDir(), "copy")
+	c := mount(t, fs)
+	defer c.Unmount()
+	f, err := c.FCreate("/drafts/copy", 0664, p.OWRITE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	// Writes save only the range written, at any offset.
+	for _, w := range []struct {
+		offset int64
+		data   string
+	}{{0, "Hello"}, {5, ", world"}, {7, "W"}, {100, "!"}} {
+		if _, err := f.WriteAt([]byte(w.data), w.offset); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if b, err := ioutil.ReadFile(path); err != nil || string(b) != "Hello, World!" {
+		t.Errorf("got %q, %v on disk", b, err)
+	}
+	d := p.NewWstatDir()
+	d.Length = 5
+	if err := c.Wstat(f.Fid(), d); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := ioutil.ReadFile(path); err != nil || string(b) != "Hello" {
+		t.Errorf("got %q, %v on disk after truncating", b, err)
+	}
+}
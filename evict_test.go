@@ -0,0 +1,99 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kurrik/twittergo"
+)
+
+// Adds tweets to the fake, a minute apart, oldest first.
+func addTweetsMinutesApart(fake *fakeTwitter, texts ...string) twittergo.Timeline {
+	var timeline twittergo.Timeline
+	start := time.Now().Add(-time.Duration(len(texts)) * time.Minute)
+	for i, text := range texts {
+		tweet := fake.addTweet("john", text)
+		tweet["created_at"] = start.Add(time.Duration(i) * time.Minute).UTC().Format(time.RubyDate)
+		timeline = append(twittergo.Timeline{tweet}, timeline...)
+	}
+	return timeline
+}
+
+func TestEvictLeastRecentlyAccessed(t *testing.T) {
+	fake := newFakeTwitter()
+	defer fake.close()
+	fs, cleanup := newTestFileSystem(t, nil, &fsConfig{MaxTweets: 2})
+	defer cleanup()
+	home := fs.root.children["home"]
+	timeline := addTweetsMinutesApart(fake, "one", "two")
+	home.addTimeline(fs.tweets, fs.filters, timeline)
+	oldest := fs.tweets.get(timeline[1].IdStr())
+	fs.touch(oldest)
+
+	third := fake.addTweet("john", "three")
+	home.addTweet(fs.tweets, third)
+	fs.evict()
+	if len(fs.tweets.tweets) != 2 {
+		t.Fatalf("got %d tweets, want 2", len(fs.tweets.tweets))
+	}
+	if home.children[timeline[0].IdStr()] != nil {
+		t.Error("least recently accessed tweet not evicted")
+	}
+	if home.children[oldest.dir.Name] != oldest || home.children[third.IdStr()] == nil {
+		t.Error("recently accessed tweet evicted")
+	}
+}
+
+func TestEvictIdleUsers(t *testing.T) {
+	fs, cleanup := newTestFileSystem(t, nil, &fsConfig{userIdleTime: time.Hour})
+	defer cleanup()
+	users := fs.root.children["users"]
+	users.loaded = true
+	for _, name := range []string{"idle", "used", "friend"} {
+		user := users.addUser(twitterUser{ScreenName: name})
+		user.loaded = true
+		user.accessed = time.Now().Add(-2 * time.Hour)
+	}
+	users.children["friend"].followed = true
+
+	// Using a timeline via the control file counts as accessing it.
+	if _, err := fs.resolveTimeline("@used"); err != nil {
+		t.Fatal(err)
+	}
+	fs.evict()
+	if users.children["idle"] != nil {
+		t.Error("idle user not evicted")
+	}
+	if users.children["used"] == nil {
+		t.Error("user accessed via ctl evicted")
+	}
+	if users.children["friend"] == nil {
+		t.Error("followed user evicted")
+	}
+}
+
+func TestTrim(t *testing.T) {
+	fake := newFakeTwitter()
+	defer fake.close()
+	fs, cleanup := newTestFileSystem(t, nil, &fsConfig{})
+	defer cleanup()
+	home := fs.root.children["home"]
+	timeline := addTweetsMinutesApart(fake, "one", "two", "three", "four")
+	home.addTimeline(fs.tweets, fs.filters, timeline)
+
+	home.trim(fs.tweets, 2)
+	if len(home.children) != 2 || home.children[timeline[0].IdStr()] == nil || home.children[timeline[1].IdStr()] == nil {
+		t.Errorf("got %d tweets, want the 2 newest", len(home.children))
+	}
+	if home.minID != timeline[1].IdStr() || home.maxID != timeline[0].IdStr() {
+		t.Errorf("got range %s-%s", home.minID, home.maxID)
+	}
+	if fs.tweets.get(timeline[3].IdStr()) != nil {
+		t.Error("trimmed tweet still stored")
+	}
+
+	home.trim(fs.tweets, 0)
+	if len(home.children) != 0 || home.minID != "" || home.maxID != "" || len(fs.tweets.tweets) != 0 {
+		t.Errorf("got %d tweets, range %q-%q after trimming all", len(home.children), home.minID, home.maxID)
+	}
+}
@@ -0,0 +1,503 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kurrik/oauth1a"
+	"github.com/kurrik/twittergo"
+	"github.com/lionkov/go9p/p"
+	"github.com/lionkov/go9p/p/clnt"
+	"github.com/lionkov/go9p/p/srv"
+)
+
+// A fake Twitter API, keeping tweets in memory, to test what the file
+// system does with the responses. The authenticated user is @janet.
+type fakeTwitter struct {
+	server *httptest.Server
+
+	mu     sync.Mutex
+	nextID int64
+	tweets map[string]twittergo.Tweet
+
+	// Users followed by @janet.
+	following map[string]bool
+
+	// If positive, how many tweets can be posted before hitting the
+	// daily limit.
+	updatesLeft int
+
+	// Media uploaded, by media id.
+	uploads map[string]*fakeUpload
+
+	// The requests served, as method and path.
+	calls []string
+
+	// If set, each request is signalled on it on arrival, then waits
+	// for a value before being served, for tests to act meanwhile.
+	stall chan struct{}
+}
+
+// Media uploaded in one request, or in chunks.
+type fakeUpload struct {
+	mediaType string
+	category  string
+	chunked   bool
+	total     int
+	segments  [][]byte
+	finalized bool
+	checked   bool
+	alt       string
+}
+
+func (u *fakeUpload) size() int {
+	size := 0
+	for _, segment := range u.segments {
+		size += len(segment)
+	}
+	return size
+}
+
+const fakeScreenName = "janet"
+
+func newFakeTwitter() *fakeTwitter {
+	f := &fakeTwitter{
+		nextID:    1300000000000000000,
+		tweets:    make(map[string]twittergo.Tweet),
+		following: make(map[string]bool),
+		uploads:   make(map[string]*fakeUpload),
+	}
+	f.server = httptest.NewTLSServer(f)
+	return f
+}
+
+func (f *fakeTwitter) close() {
+	f.server.Close()
+}
+
+func (f *fakeTwitter) client() *twittergo.Client {
+	config := &oauth1a.ClientConfig{ConsumerKey: "key", ConsumerSecret: "secret"}
+	client := twittergo.NewClient(config, oauth1a.NewAuthorizedConfig("token", "secret"))
+	client.Host = f.server.Listener.Addr().String()
+	client.HttpClient = f.server.Client()
+	uploadHost = client.Host
+	return client
+}
+
+// Adds a tweet by the given user, as if posted now.
+func (f *fakeTwitter) addTweet(author string, text string) twittergo.Tweet {
+	f.nextID++
+	idStr := strconv.FormatInt(f.nextID, 10)
+	tweet := twittergo.Tweet{
+		"id_str":     idStr,
+		"full_text":  text,
+		"created_at": time.Now().UTC().Format(time.RubyDate),
+		"user": map[string]interface{}{
+			"screen_name": author,
+			"name":        author,
+			"created_at":  "Mon Jan 02 15:04:05 +0000 2006",
+		},
+	}
+	f.tweets[idStr] = tweet
+	return tweet
+}
+
+func (f *fakeTwitter) author(tweet twittergo.Tweet) string {
+	return tweet.User().ScreenName()
+}
+
+// Returns the tweets, newest first, as filtered by the since_id,
+// max_id and count parameters.
+func (f *fakeTwitter) timeline(r *http.Request, keep func(twittergo.Tweet) bool) twittergo.Timeline {
+	var timeline twittergo.Timeline
+	sinceID, maxID := r.FormValue("since_id"), r.FormValue("max_id")
+	for idStr, tweet := range f.tweets {
+		if sinceID != "" && !idLess(sinceID, idStr) || maxID != "" && idLess(maxID, idStr) {
+			continue
+		}
+		if keep(tweet) {
+			timeline = append(timeline, tweet)
+		}
+	}
+	sort.Slice(timeline, func(a, b int) bool { return idLess(timeline[b].IdStr(), timeline[a].IdStr()) })
+	if count, err := strconv.Atoi(r.FormValue("count")); err == nil && count < len(timeline) {
+		timeline = timeline[:count]
+	}
+	return timeline
+}
+
+// Adds to a count in the tweet, e.g., favorite_count.
+func (f *fakeTwitter) count(tweet twittergo.Tweet, key string, delta int) {
+	n, _ := tweet[key].(int)
+	tweet[key] = n + delta
+}
+
+func (f *fakeTwitter) user(screenName string) map[string]interface{} {
+	return map[string]interface{}{
+		"screen_name": screenName,
+		"created_at":  "Mon Jan 02 15:04:05 +0000 2006",
+	}
+}
+
+func (f *fakeTwitter) respond(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func (f *fakeTwitter) fail(w http.ResponseWriter, status int, code int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"errors": []interface{}{map[string]interface{}{"code": code, "message": message}},
+	})
+}
+
+// Matches the tweet id in paths like /1.1/statuses/destroy/1234.json.
+var fakePathIDExpr = regexp.MustCompile(`/([0-9]+)\.json$`)
+
+func (f *fakeTwitter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if f.stall != nil {
+		f.stall <- struct{}{}
+		<-f.stall
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	call := r.Method + " " + r.URL.Path
+	var pathID string
+	if m := fakePathIDExpr.FindStringSubmatch(call); m != nil {
+		pathID = m[1]
+		call = fakePathIDExpr.ReplaceAllString(call, "/:id.json")
+	}
+	f.calls = append(f.calls, call)
+	switch call {
+	case "POST /1.1/statuses/update.json":
+		status := r.FormValue("status")
+		mediaIDs := r.FormValue("media_ids")
+		if status == "" && mediaIDs == "" {
+			f.fail(w, http.StatusForbidden, 170, "Missing required parameter: status.")
+			return
+		}
+		if f.updatesLeft < 0 {
+			f.fail(w, http.StatusForbidden, 185, "User is over daily status update limit.")
+			return
+		}
+		if f.updatesLeft > 0 {
+			f.updatesLeft--
+			if f.updatesLeft == 0 {
+				f.updatesLeft = -1
+			}
+		}
+		inReply := r.FormValue("in_reply_to_status_id")
+		if _, ok := f.tweets[inReply]; inReply != "" && !ok {
+			f.fail(w, http.StatusForbidden, 385, "The tweet replied to is missing.")
+			return
+		}
+		var media []interface{}
+		for _, mediaID := range strings.Split(mediaIDs, ",") {
+			if mediaID == "" {
+				continue
+			}
+			upload, ok := f.uploads[mediaID]
+			if !ok || !upload.finalized {
+				f.fail(w, http.StatusBadRequest, 324, "Invalid media id "+mediaID)
+				return
+			}
+			media = append(media, map[string]interface{}{
+				"id_str":          mediaID,
+				"type":            "photo",
+				"media_url_https": "https://pbs.twimg.com/media/" + mediaID + ".jpg",
+				"ext_alt_text":    upload.alt,
+			})
+		}
+		var quoted twittergo.Tweet
+		if attachmentURL := r.FormValue("attachment_url"); attachmentURL != "" {
+			var ok bool
+			if quoted, ok = f.tweets[path.Base(attachmentURL)]; !ok {
+				f.fail(w, http.StatusForbidden, 385, "The tweet quoted is missing.")
+				return
+			}
+		}
+		tweet := f.addTweet(fakeScreenName, status)
+		if inReply != "" {
+			tweet["in_reply_to_status_id_str"] = inReply
+		}
+		if quoted != nil {
+			tweet["is_quote_status"] = true
+			tweet["quoted_status_id_str"] = quoted.IdStr()
+			tweet["quoted_status"] = map[string]interface{}(quoted)
+		}
+		if media != nil {
+			tweet["extended_entities"] = map[string]interface{}{"media": media}
+		}
+		f.respond(w, tweet)
+	case "POST /1.1/media/upload.json", "GET /1.1/media/upload.json":
+		f.serveUpload(w, r)
+	case "POST /1.1/media/metadata/create.json":
+		var obj struct {
+			MediaID string `json:"media_id"`
+			AltText struct {
+				Text string `json:"text"`
+			} `json:"alt_text"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&obj); err != nil {
+			f.fail(w, http.StatusBadRequest, 38, err.Error())
+			return
+		}
+		upload, ok := f.uploads[obj.MediaID]
+		if !ok {
+			f.fail(w, http.StatusBadRequest, 324, "Invalid media id "+obj.MediaID)
+			return
+		}
+		upload.alt = obj.AltText.Text
+		w.WriteHeader(http.StatusOK)
+	case "GET /1.1/statuses/home_timeline.json":
+		f.respond(w, f.timeline(r, func(twittergo.Tweet) bool { return true }))
+	case "GET /1.1/statuses/user_timeline.json":
+		f.respond(w, f.timeline(r, func(tweet twittergo.Tweet) bool {
+			return f.author(tweet) == r.FormValue("screen_name")
+		}))
+	case "POST /1.1/favorites/create.json", "POST /1.1/favorites/destroy.json":
+		tweet, ok := f.tweets[r.FormValue("id")]
+		if !ok {
+			f.fail(w, http.StatusNotFound, 144, "No status found with that ID.")
+			return
+		}
+		create := call == "POST /1.1/favorites/create.json"
+		if favorited, _ := tweet["favorited"].(bool); favorited == create {
+			f.fail(w, http.StatusForbidden, 139, "You have already favorited this status, or not.")
+			return
+		}
+		tweet["favorited"] = create
+		if create {
+			f.count(tweet, "favorite_count", 1)
+		} else {
+			f.count(tweet, "favorite_count", -1)
+		}
+		f.respond(w, tweet)
+	case "POST /1.1/statuses/retweet/:id.json":
+		tweet, ok := f.tweets[pathID]
+		if !ok {
+			f.fail(w, http.StatusNotFound, 144, "No status found with that ID.")
+			return
+		}
+		if retweeted, _ := tweet["retweeted"].(bool); retweeted {
+			f.fail(w, http.StatusForbidden, 327, "You have already retweeted this Tweet.")
+			return
+		}
+		tweet["retweeted"] = true
+		f.count(tweet, "retweet_count", 1)
+		wrapper := f.addTweet(fakeScreenName, "RT @"+f.author(tweet)+": "+tweet.FullText())
+		wrapper["retweeted_status"] = map[string]interface{}(tweet)
+		f.respond(w, wrapper)
+	case "POST /1.1/statuses/unretweet/:id.json":
+		tweet, ok := f.tweets[pathID]
+		if !ok {
+			f.fail(w, http.StatusNotFound, 144, "No status found with that ID.")
+			return
+		}
+		if retweeted, _ := tweet["retweeted"].(bool); retweeted {
+			tweet["retweeted"] = false
+			f.count(tweet, "retweet_count", -1)
+		}
+		for idStr, wrapper := range f.tweets {
+			if rt := retweetedStatus(wrapper); rt != nil && rt.IdStr() == pathID && f.author(wrapper) == fakeScreenName {
+				delete(f.tweets, idStr)
+			}
+		}
+		f.respond(w, tweet)
+	case "POST /1.1/statuses/destroy/:id.json":
+		tweet, ok := f.tweets[pathID]
+		if !ok {
+			f.fail(w, http.StatusNotFound, 144, "No status found with that ID.")
+			return
+		}
+		if f.author(tweet) != fakeScreenName {
+			f.fail(w, http.StatusForbidden, 183, "You may not delete another user's status.")
+			return
+		}
+		delete(f.tweets, pathID)
+		f.respond(w, tweet)
+	case "GET /1.1/users/show.json":
+		f.respond(w, f.user(r.FormValue("screen_name")))
+	case "GET /1.1/friends/list.json":
+		var users []interface{}
+		for name := range f.following {
+			users = append(users, f.user(name))
+		}
+		f.respond(w, map[string]interface{}{"users": users, "next_cursor_str": "0"})
+	case "POST /1.1/friendships/create.json":
+		name := r.FormValue("screen_name")
+		f.following[name] = true
+		f.respond(w, f.user(name))
+	case "POST /1.1/friendships/destroy.json":
+		name := r.FormValue("screen_name")
+		delete(f.following, name)
+		f.respond(w, f.user(name))
+	case "GET /1.1/statuses/show.json":
+		tweet, ok := f.tweets[r.FormValue("id")]
+		if !ok {
+			f.fail(w, http.StatusNotFound, 144, "No status found with that ID.")
+			return
+		}
+		f.respond(w, tweet)
+	default:
+		f.fail(w, http.StatusNotFound, 34, "Sorry, that page does not exist.")
+	}
+}
+
+// Serves uploads in one request, and the chunked upload commands,
+// checking that chunks are sent in order, are no larger than
+// uploadChunkSize, and add up to the size given by INIT.
+func (f *fakeTwitter) serveUpload(w http.ResponseWriter, r *http.Request) {
+	readMedia := func() ([]byte, bool) {
+		file, _, err := r.FormFile("media")
+		if err != nil {
+			f.fail(w, http.StatusBadRequest, 38, "media parameter is missing.")
+			return nil, false
+		}
+		defer file.Close()
+		data, err := ioutil.ReadAll(file)
+		if err != nil {
+			f.fail(w, http.StatusBadRequest, 38, err.Error())
+			return nil, false
+		}
+		return data, true
+	}
+	lookup := func() (*fakeUpload, bool) {
+		upload, ok := f.uploads[r.FormValue("media_id")]
+		if !ok {
+			f.fail(w, http.StatusBadRequest, 324, "Invalid media id.")
+		}
+		return upload, ok
+	}
+	command := r.FormValue("command")
+	if r.Method == http.MethodGet && command != "STATUS" {
+		f.fail(w, http.StatusNotFound, 34, "Sorry, that page does not exist.")
+		return
+	}
+	switch command {
+	case "":
+		data, ok := readMedia()
+		if !ok {
+			return
+		}
+		f.nextID++
+		mediaID := strconv.FormatInt(f.nextID, 10)
+		f.uploads[mediaID] = &fakeUpload{
+			mediaType: http.DetectContentType(data),
+			segments:  [][]byte{data},
+			finalized: true,
+		}
+		f.respond(w, map[string]interface{}{"media_id_string": mediaID})
+	case "INIT":
+		total, err := strconv.Atoi(r.FormValue("total_bytes"))
+		if err != nil || total <= 0 || r.FormValue("media_type") == "" {
+			f.fail(w, http.StatusBadRequest, 38, "Bad INIT parameters.")
+			return
+		}
+		f.nextID++
+		mediaID := strconv.FormatInt(f.nextID, 10)
+		f.uploads[mediaID] = &fakeUpload{
+			mediaType: r.FormValue("media_type"),
+			category:  r.FormValue("media_category"),
+			chunked:   true,
+			total:     total,
+		}
+		f.respond(w, map[string]interface{}{"media_id_string": mediaID})
+	case "APPEND":
+		upload, ok := lookup()
+		if !ok {
+			return
+		}
+		if upload.finalized || r.FormValue("segment_index") != strconv.Itoa(len(upload.segments)) {
+			f.fail(w, http.StatusBadRequest, 38, "Segment out of order.")
+			return
+		}
+		data, ok := readMedia()
+		if !ok {
+			return
+		}
+		if len(data) == 0 || len(data) > uploadChunkSize || upload.size()+len(data) > upload.total {
+			f.fail(w, http.StatusBadRequest, 38, "Bad segment size.")
+			return
+		}
+		upload.segments = append(upload.segments, data)
+		w.WriteHeader(http.StatusNoContent)
+	case "FINALIZE":
+		upload, ok := lookup()
+		if !ok {
+			return
+		}
+		if upload.size() != upload.total {
+			f.fail(w, http.StatusBadRequest, 38, "File size does not match total_bytes.")
+			return
+		}
+		upload.finalized = true
+		f.respond(w, map[string]interface{}{
+			"media_id_string": r.FormValue("media_id"),
+			"processing_info": map[string]interface{}{"state": "pending", "check_after_secs": 0},
+		})
+	case "STATUS":
+		upload, ok := lookup()
+		if !ok {
+			return
+		}
+		upload.checked = true
+		f.respond(w, map[string]interface{}{
+			"media_id_string": r.FormValue("media_id"),
+			"processing_info": map[string]interface{}{"state": "succeeded"},
+		})
+	default:
+		f.fail(w, http.StatusBadRequest, 38, "Unknown command.")
+	}
+}
+
+// Returns a file system for tests, with state in a temporary
+// directory, to be removed by calling the returned function.
+func newTestFileSystem(t *testing.T, client *twittergo.Client, c *fsConfig) (*fsOps, func()) {
+	dir, err := ioutil.TempDir("", "twitterfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cleanup := func() { _ = os.RemoveAll(dir) }
+	c.dir = dir
+	fs, err := newFileSystemOps(client, c)
+	if err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+	// Tests call into the file system as requests do, with the lock
+	// held, which calls to Twitter release.
+	fs.mu.Lock()
+	return fs, cleanup
+}
+
+// Serves the file system over a pipe, and returns a 9P client mounting
+// it, for tests to go through the same requests as users. The lock
+// is released for the requests to take it.
+func mount(t *testing.T, fs *fsOps) *clnt.Clnt {
+	fs.mu.Unlock()
+	s := new(srv.Srv)
+	s.Dotu = false
+	s.Id = "twitter"
+	s.Start(fs)
+	client, server := net.Pipe()
+	go s.NewConn(server)
+	c, err := clnt.MountConn(client, "", 8192, p.OsUsers.Uid2User(os.Geteuid()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
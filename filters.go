@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/kurrik/twittergo"
+	"github.com/pkg/errors"
+)
+
+// A filter rule hides matching tweets from timeline listings. Rules
+// are written in a small language, one per line:
+//
+//	mute user @janet
+//	mute word /regexp/
+//	mute word plain text
+//	hide retweets [from @janet] [in home|mentions|@john]
+//	hide replies [from @janet] [in home|mentions|@john]
+//
+// A muted user's tweets and retweets of their tweets are hidden
+// everywhere. Words are matched case-insensitively unless given as a
+// regular expression.
+type filterRule struct {
+	// The rule as written, used to print it back and to remove it.
+	text string
+
+	user     string         // Screen name, lower case, for "mute user" and "from" clauses.
+	word     *regexp.Regexp // For "mute word".
+	retweets bool           // For "hide retweets".
+	replies  bool           // For "hide replies".
+	timeline string         // For "in" clauses, as returned by timelineName.
+
+	// The ids of the tweets this rule hid, each counted once however
+	// many times it's fetched again.
+	hidden map[string]struct{}
+}
+
+func parseFilterRule(line string) (*filterRule, error) {
+	rule := &filterRule{text: line}
+	fields := strings.Fields(line)
+	if len(fields) < 2 {
+		return nil, errors.Errorf("%q: incomplete rule", line)
+	}
+	switch {
+	case fields[0] == "mute" && fields[1] == "user" && len(fields) == 3:
+		if !strings.HasPrefix(fields[2], "@") || len(fields[2]) == 1 {
+			return nil, errors.Errorf("%q: expected @user", line)
+		}
+		rule.user = strings.ToLower(fields[2][1:])
+	case fields[0] == "mute" && fields[1] == "word" && len(fields) > 2:
+		word := strings.TrimSpace(strings.SplitN(line, "word", 2)[1])
+		var expr string
+		if len(word) > 1 && word[0] == '/' && word[len(word)-1] == '/' {
+			expr = word[1 : len(word)-1]
+		} else {
+			expr = "(?i)" + regexp.QuoteMeta(word)
+		}
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, errors.Wrapf(err, "%q", line)
+		}
+		rule.word = re
+	case fields[0] == "hide" && (fields[1] == "retweets" || fields[1] == "replies"):
+		rule.retweets = fields[1] == "retweets"
+		rule.replies = fields[1] == "replies"
+		for rest := fields[2:]; len(rest) > 0; rest = rest[2:] {
+			if len(rest) < 2 {
+				return nil, errors.Errorf("%q: incomplete %q clause", line, rest[0])
+			}
+			switch rest[0] {
+			case "from":
+				if !strings.HasPrefix(rest[1], "@") || len(rest[1]) == 1 {
+					return nil, errors.Errorf("%q: expected @user", line)
+				}
+				rule.user = strings.ToLower(rest[1][1:])
+			case "in":
+				if rest[1] != "home" && rest[1] != "mentions" && !strings.HasPrefix(rest[1], "@") {
+					return nil, errors.Errorf("%q: expected home, mentions, or @user", line)
+				}
+				rule.timeline = strings.ToLower(rest[1])
+			default:
+				return nil, errors.Errorf("%q: unexpected %q", line, rest[0])
+			}
+		}
+	default:
+		return nil, errors.Errorf("%q: unknown rule", line)
+	}
+	return rule, nil
+}
+
+func (rule *filterRule) matches(timeline string, tweet twittergo.Tweet) bool {
+	if rule.timeline != "" && rule.timeline != timeline {
+		return false
+	}
+	author := strings.ToLower(tweet.User().ScreenName())
+	retweeted := retweetedStatus(tweet)
+	switch {
+	case rule.word != nil:
+		if rule.word.MatchString(tweetContent(tweet)) {
+			return true
+		}
+		return retweeted != nil && rule.word.MatchString(tweetContent(retweeted))
+	case rule.retweets:
+		return retweeted != nil && (rule.user == "" || rule.user == author)
+	case rule.replies:
+		_, isReply := get(tweet, "in_reply_to_status_id_str")
+		return isReply && (rule.user == "" || rule.user == author)
+	default:
+		if author == rule.user {
+			return true
+		}
+		return retweeted != nil && strings.ToLower(retweeted.User().ScreenName()) == rule.user
+	}
+}
+
+// The set of filter rules, persisted to a file, one rule per line.
+type filterSet struct {
+	path  string
+	rules []*filterRule
+}
+
+// Loads the rules from the given file, if it exists.
+func loadFilters(path string) (*filterSet, error) {
+	f := &filterSet{path: path}
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return f, nil
+	}
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if err := f.apply(b); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *filterSet) save() error {
+	var b bytes.Buffer
+	for _, rule := range f.rules {
+		_, _ = fmt.Fprintln(&b, rule.text)
+	}
+	return errors.WithStack(ioutil.WriteFile(f.path, b.Bytes(), 0600))
+}
+
+// Applies the lines in data, each either a rule to add, "remove" and
+// a rule to remove, or "clear" to remove all rules. Anything from a
+// tab followed by # is a comment, so that what's read from the filters
+// file can be written back.
+func (f *filterSet) apply(data []byte) error {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "\t#"); i != -1 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "":
+		case line == "clear":
+			f.rules = nil
+		case strings.HasPrefix(line, "remove "):
+			f.remove(strings.TrimSpace(line[7:]))
+		default:
+			rule, err := parseFilterRule(line)
+			if err != nil {
+				return err
+			}
+			f.add(rule)
+		}
+	}
+	return errors.WithStack(scanner.Err())
+}
+
+// Applies the lines in data, and saves the resulting rules, or does
+// nothing if any line is bad or saving fails. If replace is set, the
+// lines replace the rules rather than being applied to them.
+func (f *filterSet) update(data []byte, replace bool) error {
+	next := &filterSet{path: f.path}
+	if !replace {
+		next.rules = append([]*filterRule(nil), f.rules...)
+	}
+	if err := next.apply(data); err != nil {
+		return err
+	}
+	if err := next.save(); err != nil {
+		return err
+	}
+	f.rules = next.rules
+	return nil
+}
+
+func (f *filterSet) add(rule *filterRule) {
+	for _, r := range f.rules {
+		if r.text == rule.text {
+			return
+		}
+	}
+	f.rules = append(f.rules, rule)
+}
+
+func (f *filterSet) remove(text string) {
+	for i, r := range f.rules {
+		if r.text == text {
+			f.rules = append(f.rules[:i], f.rules[i+1:]...)
+			return
+		}
+	}
+}
+
+// Reports whether the tweet should be hidden from the given timeline,
+// crediting the first matching rule.
+func (f *filterSet) hides(timeline string, tweet twittergo.Tweet) bool {
+	if rule := f.match(timeline, tweet); rule != nil {
+		if rule.hidden == nil {
+			rule.hidden = make(map[string]struct{})
+		}
+		rule.hidden[tweet.IdStr()] = struct{}{}
+		return true
+	}
+	return false
+}
+
+// Returns the first rule hiding the tweet from the given timeline, if
+// any.
+func (f *filterSet) match(timeline string, tweet twittergo.Tweet) *filterRule {
+	for _, rule := range f.rules {
+		if rule.matches(timeline, tweet) {
+			return rule
+		}
+	}
+	return nil
+}
+
+func (f *filterSet) format() []byte {
+	var b bytes.Buffer
+	for _, rule := range f.rules {
+		_, _ = fmt.Fprintf(&b, "%s\t# hidden %d\n", rule.text, len(rule.hidden))
+	}
+	return b.Bytes()
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/kurrik/twittergo"
+	"github.com/lionkov/go9p/p"
+)
+
+func TestFilterRules(t *testing.T) {
+	tweet := func(author string, text string, fields map[string]interface{}) twittergo.Tweet {
+		t := twittergo.Tweet{
+			"full_text": text,
+			"user":      map[string]interface{}{"screen_name": author},
+		}
+		for k, v := range fields {
+			t[k] = v
+		}
+		return t
+	}
+	plain := tweet("Janet", "Hello, World", nil)
+	reply := tweet("janet", "@john indeed", map[string]interface{}{"in_reply_to_status_id_str": "12345678"})
+	retweet := tweet("john", "RT @janet: Hello", map[string]interface{}{"retweeted_status": map[string]interface{}(plain)})
+	testCases := []struct {
+		rule     string
+		timeline string
+		tweet    twittergo.Tweet
+		hidden   bool
+	}{
+		{"mute user @janet", "home", plain, true},
+		{"mute user @JANET", "@janet", plain, true},
+		{"mute user @janet", "home", retweet, true},
+		{"mute user @john", "home", plain, false},
+		{"mute word world", "home", plain, true},
+		{"mute word /^Hello/", "home", plain, true},
+		{"mute word /^hello/", "home", plain, false},
+		{"mute word world", "home", retweet, true},
+		{"hide retweets", "mentions", retweet, true},
+		{"hide retweets in home", "home", retweet, true},
+		{"hide retweets in home", "@john", retweet, false},
+		{"hide retweets in home", "home", plain, false},
+		{"hide retweets from @janet", "home", retweet, false},
+		{"hide replies from @janet", "home", reply, true},
+		{"hide replies from @janet in @janet", "home", reply, false},
+		{"hide replies", "home", plain, false},
+	}
+	for _, tc := range testCases {
+		rule, err := parseFilterRule(tc.rule)
+		if err != nil {
+			t.Errorf("%q: %v", tc.rule, err)
+			continue
+		}
+		if got, want := rule.matches(tc.timeline, tc.tweet), tc.hidden; got != want {
+			t.Errorf("%q in %s on %q: got %v, want %v", tc.rule, tc.timeline, tc.tweet.FullText(), got, want)
+		}
+	}
+	for _, bad := range []string{
+		"mute",
+		"mute user janet",
+		"mute word /(/",
+		"hide retweets in",
+		"hide retweets in timbuktu",
+		"hide replies since @janet",
+		"show retweets",
+	} {
+		if _, err := parseFilterRule(bad); err == nil {
+			t.Errorf("%q: got no error", bad)
+		}
+	}
+}
+
+func TestHiddenTweetsNotStored(t *testing.T) {
+	fake := newFakeTwitter()
+	defer fake.close()
+	fs, cleanup := newTestFileSystem(t, fake.client(), &fsConfig{})
+	defer cleanup()
+	rule, err := parseFilterRule("mute user @janet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs.filters.add(rule)
+	home := fs.root.children["home"]
+	hidden := fake.addTweet("janet", "Hello")
+	shown := fake.addTweet("john", "Hi")
+	home.addTimeline(fs.tweets, fs.filters, twittergo.Timeline{shown, hidden})
+	if home.children[hidden.IdStr()] != nil || fs.tweets.get(hidden.IdStr()) != nil {
+		t.Error("hidden tweet listed or stored")
+	}
+	if home.children[shown.IdStr()] == nil || len(fs.tweets.tweets) != 1 {
+		t.Errorf("got %d tweets stored, want 1", len(fs.tweets.tweets))
+	}
+	// Fetched again, e.g., on reload, hidden tweets count once.
+	home.addTimeline(fs.tweets, fs.filters, twittergo.Timeline{shown, hidden})
+	if got, want := string(fs.filters.format()), "mute user @janet\t# hidden 1\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	// Hidden tweets can be walked to by id, but that doesn't list them.
+	n, perr := fs.walk1(home, hidden.IdStr())
+	if perr != nil || n == nil || len(n.buffer) == 0 {
+		t.Fatalf("walk to hidden tweet: %v", perr)
+	}
+	if home.children[hidden.IdStr()] != nil || fs.tweets.get(hidden.IdStr()) != nil {
+		t.Error("hidden tweet walked to listed or stored")
+	}
+	janet, perr := fs.walk1(fs.root.children["users"], "janet")
+	if perr != nil || janet == nil {
+		t.Fatalf("walk to user: %v", perr)
+	}
+	janet.addTweet(fs.tweets, hidden)
+	if n, perr := fs.walk1(home, hidden.IdStr()); perr != nil || n != fs.tweets.get(hidden.IdStr()) || home.children[hidden.IdStr()] != nil {
+		t.Errorf("walk to hidden tweet stored for another timeline: %v", perr)
+	}
+}
+
+func TestWriteFilters(t *testing.T) {
+	fs, cleanup := newTestFileSystem(t, nil, &fsConfig{})
+	defer cleanup()
+	c := mount(t, fs)
+	defer c.Unmount()
+	rules := func() string {
+		fs.mu.Lock()
+		defer fs.mu.Unlock()
+		return string(fs.filters.format())
+	}
+	saved := func() string {
+		b, _ := ioutil.ReadFile(fs.filters.path)
+		return string(b)
+	}
+
+	// Lines split across writes are applied once complete, and a last
+	// line without a newline on close.
+	f, err := c.FOpen("/filters", p.OWRITE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"mute user @ja", "net\nmute word ", "hello\nhide replies"} {
+		if _, err := f.Write([]byte(s)); err != nil {
+			t.Fatalf("%q: %v", s, err)
+		}
+	}
+	if got, want := saved(), "mute user @janet\nmute word hello\n"; got != want {
+		t.Errorf("got %q saved before close, want %q", got, want)
+	}
+	_ = f.Close()
+	want := "mute user @janet\nmute word hello\nhide replies\n"
+	if got := saved(); got != want {
+		t.Errorf("got %q saved, want %q", got, want)
+	}
+
+	// A write with a bad line changes nothing.
+	f, err = c.FOpen("/filters", p.OWRITE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte("remove mute user @janet\nmute nothing\n")); err == nil {
+		t.Error("bad rule accepted")
+	}
+	_ = f.Close()
+	if got := saved(); got != want {
+		t.Errorf("got %q saved after failed write, want %q", got, want)
+	}
+	if !strings.HasPrefix(rules(), "mute user @janet\t") {
+		t.Errorf("rules changed after failed write: %q", rules())
+	}
+
+	// Truncating replaces the rules with those written, saved, or
+	// clears them if nothing is written.
+	f, err = c.FOpen("/filters", p.OWRITE|p.OTRUNC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte("hide retweets\n")); err != nil {
+		t.Fatal(err)
+	}
+	_ = f.Close()
+	if got := saved(); got != "hide retweets\n" {
+		t.Errorf("got %q saved after rewrite", got)
+	}
+	f, err = c.FOpen("/filters", p.OWRITE|p.OTRUNC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = f.Close()
+	if got := saved(); got != "" || rules() != "" {
+		t.Errorf("got %q saved, rules %q after truncation", got, rules())
+	}
+}
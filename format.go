@@ -1,42 +1,105 @@
 package main
 
 import (
-	"bytes"
-	"fmt"
+	"html"
+	"net/url"
 	"path/filepath"
+	"regexp"
 	"sort"
 	"strings"
-	"time"
+	"unicode/utf16"
 
 	"github.com/kurrik/twittergo"
 )
 
-// Try to convert tweet URLs to relative paths (from a user directory).
-// Identity on non-tweet URLs.
-func localizeURL(currentUser, url string) string {
-	local := url
-	if !strings.HasPrefix(local, "https://twitter.com/") {
-		return url
-	}
-	local = local[20:]
-	i := strings.IndexByte(local, '/')
-	if i == -1 {
-		return url
-	}
-	user := strings.ToLower(local[:i])
-	local = local[i:]
-	if !strings.HasPrefix(local, "/status/") {
-		return url
-	}
-	idStr := local[8:]
-	if len(idStr) == 0 {
-		return url
-	}
-	for i := 0; i < len(idStr); i++ {
-		if idStr[i] < '0' || idStr[i] > '9' {
-			return url
+var (
+	twitterHosts = map[string]bool{
+		"twitter.com":        true,
+		"www.twitter.com":    true,
+		"mobile.twitter.com": true,
+		"x.com":              true,
+		"www.x.com":          true,
+		"mobile.x.com":       true,
+	}
+
+	// Top-level paths that look like screen names but aren't.
+	reservedNames = map[string]bool{
+		"explore":       true,
+		"hashtag":       true,
+		"home":          true,
+		"i":             true,
+		"intent":        true,
+		"login":         true,
+		"messages":      true,
+		"notifications": true,
+		"privacy":       true,
+		"search":        true,
+		"settings":      true,
+		"share":         true,
+		"status":        true,
+		"statuses":      true,
+		"tos":           true,
+	}
+
+	screenNameExpr = regexp.MustCompile(`^[A-Za-z0-9_]{1,15}$`)
+)
+
+func isDigits(s string) bool {
+	if len(s) == 0 {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
 		}
 	}
+	return true
+}
+
+// Try to convert tweet URLs to relative paths (from a user directory),
+// and profile URLs to relative paths of user directories. Recognizes
+// twitter.com and x.com, with or without www. or mobile., over HTTP or
+// HTTPS, with paths like /user/status/id, /user/statuses/id,
+// /user/status/id/photo/1 and /i/web/status/id, ignoring query strings
+// and fragments. Identity on other URLs.
+func localizeURL(currentUser, rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.User != nil {
+		return rawURL
+	}
+	if !twitterHosts[strings.ToLower(u.Host)] {
+		return rawURL
+	}
+	segments := strings.Split(strings.TrimSuffix(u.Path, "/"), "/")[1:]
+	isStatus := func(s string) bool { return s == "status" || s == "statuses" }
+	var user, idStr string
+	switch {
+	case len(segments) == 1:
+		user = segments[0]
+	case len(segments) == 3 && isStatus(segments[1]):
+		user, idStr = segments[0], segments[2]
+	case len(segments) == 5 && isStatus(segments[1]) && (segments[3] == "photo" || segments[3] == "video") && isDigits(segments[4]):
+		user, idStr = segments[0], segments[2]
+	case len(segments) == 4 && segments[0] == "i" && segments[1] == "web" && isStatus(segments[2]) && isDigits(segments[3]):
+		// No user in the URL, but walking to the id from any timeline
+		// directory finds the tweet.
+		return segments[3]
+	default:
+		return rawURL
+	}
+	if !screenNameExpr.MatchString(user) || reservedNames[strings.ToLower(user)] {
+		return rawURL
+	}
+	user = strings.ToLower(user)
+	if idStr == "" {
+		if currentUser == user {
+			return "."
+		}
+		return filepath.Join("..", user)
+	}
+	if !isDigits(idStr) {
+		return rawURL
+	}
 	if currentUser == user {
 		return idStr
 	}
@@ -68,39 +131,77 @@ func collectURLs(set map[string]struct{}, values either, fieldName string) {
 	}
 }
 
-// The formatting of the tweet text is quite tentative and subject to change.
-func formatTweet(currentUser string, tweet twittergo.Tweet) []byte {
-	var text bytes.Buffer
+func tweetContent(tweet twittergo.Tweet) string {
 	content := tweet.FullText()
 	if content == "" {
 		content = tweet.Text()
 	}
-	_, _ = fmt.Fprintf(
-		&text,
-		"@%s — %s — %s\n",
-		strings.ToLower(tweet.User().ScreenName()),
-		tweet.CreatedAt().Format(time.RFC3339),
-		content,
-	)
-	if tweetPath := parentRelativePath(currentUser, tweet); tweetPath != "" {
-		_, _ = fmt.Fprintf(&text, "Parent: %s\n", tweetPath)
-	}
-	if tweetPath := retweetedRelativePath(currentUser, tweet); tweetPath != "" {
-		_, _ = fmt.Fprintf(&text, "Retweets: %s\n", tweetPath)
-	}
-	// Collect URLs from various parts of the Tweet JSON.
-	urlSet := make(map[string]struct{})
-	collectURLs(urlSet, either{urls: tweet.Entities().URLs()}, "expanded_url")
-	collectURLs(urlSet, either{urls: tweet.ExtendedEntities().URLs()}, "expanded_url")
-	collectURLs(urlSet, either{media: tweet.Entities().Media()}, "media_url_https")
-	collectURLs(urlSet, either{media: tweet.ExtendedEntities().Media()}, "media_url_https")
-	var urlList []string
-	for url := range urlSet {
-		urlList = append(urlList, url)
-	}
-	sort.Strings(urlList)
-	for _, url := range urlList {
-		_, _ = fmt.Fprintf(&text, "Link: %s\n", localizeURL(currentUser, url))
-	}
-	return text.Bytes()
+	return content
+}
+
+// A replacement of the text at indices [start, end) of a tweet's text.
+type textEdit struct {
+	start, end int
+	url        string // The t.co link expected at the indices.
+	with       string
+}
+
+func entityEdit(entity map[string]interface{}, with string) (textEdit, bool) {
+	indices, _ := entity["indices"].([]interface{})
+	if len(indices) != 2 {
+		return textEdit{}, false
+	}
+	start, ok1 := indices[0].(float64)
+	end, ok2 := indices[1].(float64)
+	url, ok3 := entity["url"].(string)
+	if !ok1 || !ok2 || !ok3 || start < 0 || end < start {
+		return textEdit{}, false
+	}
+	return textEdit{start: int(start), end: int(end), url: url, with: with}, true
+}
+
+// Returns the tweet text with t.co links replaced by the URLs they
+// stand for (localized, if tweet URLs), links to attached media
+// removed, and HTML entities decoded. The entity indices count UTF-16
+// code units in the text as returned by the API, before decoding.
+func expandText(currentUser string, tweet twittergo.Tweet) string {
+	text := tweetContent(tweet)
+	var edits []textEdit
+	for _, u := range tweet.Entities().URLs() {
+		expanded, _ := u["expanded_url"].(string)
+		if expanded == "" {
+			continue
+		}
+		if edit, ok := entityEdit(u, localizeURL(currentUser, expanded)); ok {
+			edits = append(edits, edit)
+		}
+	}
+	media := tweet.ExtendedEntities().Media()
+	if len(media) == 0 {
+		media = tweet.Entities().Media()
+	}
+	for _, m := range media {
+		if edit, ok := entityEdit(m, ""); ok {
+			edits = append(edits, edit)
+		}
+	}
+	sort.Slice(edits, func(a, b int) bool { return edits[a].start < edits[b].start })
+	units := utf16.Encode([]rune(text))
+	var b strings.Builder
+	last := 0
+	for _, edit := range edits {
+		// Skip overlapping entities (e.g., several media sharing one
+		// link) and entities not where they should be.
+		if edit.start < last || edit.end > len(units) {
+			continue
+		}
+		if string(utf16.Decode(units[edit.start:edit.end])) != edit.url {
+			continue
+		}
+		b.WriteString(html.UnescapeString(string(utf16.Decode(units[last:edit.start]))))
+		b.WriteString(edit.with)
+		last = edit.end
+	}
+	b.WriteString(html.UnescapeString(string(utf16.Decode(units[last:]))))
+	return strings.TrimRight(b.String(), " \n")
 }
@@ -1,10 +1,16 @@
 package main
 
 import (
+	"io/ioutil"
 	"math/rand"
 	"reflect"
+	"strings"
 	"testing"
 	"testing/quick"
+	"time"
+
+	"github.com/kurrik/twittergo"
+	"github.com/lionkov/go9p/p"
 )
 
 func TestLocalizeURL(t *testing.T) {
@@ -19,6 +25,19 @@ func TestLocalizeURL(t *testing.T) {
 			{"netbsdsrc", "https://twitter.com/NPR/status/1274574891338129409", "../npr/1274574891338129409"},
 			{"npr", "https://twitter.com/NPR/status/1274574891338129409", "1274574891338129409"},
 			{"npr", "https://twitter.com/DLangille/status/1267451982383656961", "../dlangille/1267451982383656961"},
+			{"npr", "https://x.com/NPR/status/1274574891338129409", "1274574891338129409"},
+			{"npr", "https://mobile.twitter.com/netbsdsrc/status/1271800794002710540", "../netbsdsrc/1271800794002710540"},
+			{"npr", "https://www.twitter.com/netbsdsrc/status/1271800794002710540", "../netbsdsrc/1271800794002710540"},
+			{"npr", "http://twitter.com/netbsdsrc/status/1271800794002710540", "../netbsdsrc/1271800794002710540"},
+			{"npr", "https://twitter.com/netbsdsrc/statuses/1271800794002710540", "../netbsdsrc/1271800794002710540"},
+			{"npr", "https://twitter.com/netbsdsrc/status/1271800794002710540?s=20", "../netbsdsrc/1271800794002710540"},
+			{"npr", "https://twitter.com/netbsdsrc/status/1271800794002710540#m", "../netbsdsrc/1271800794002710540"},
+			{"npr", "https://twitter.com/netbsdsrc/status/1271800794002710540/photo/1", "../netbsdsrc/1271800794002710540"},
+			{"npr", "https://twitter.com/netbsdsrc/status/1271800794002710540/video/1?s=21&t=x", "../netbsdsrc/1271800794002710540"},
+			{"npr", "https://twitter.com/i/web/status/1271800794002710540", "1271800794002710540"},
+			{"npr", "https://twitter.com/NetBSDsrc", "../netbsdsrc"},
+			{"npr", "https://x.com/netbsdsrc/?s=09", "../netbsdsrc"},
+			{"npr", "https://twitter.com/npr", "."},
 		}
 		for _, tc := range testCases {
 			if got, want := localizeURL(tc.user, tc.url), tc.path; got != want {
@@ -49,6 +68,21 @@ func TestLocalizeURL(t *testing.T) {
 			t.Error(err)
 		}
 	})
+	t.Run("happy paths by varying host, scheme and suffix", func(t *testing.T) {
+		prefixes := []string{"https://", "http://"}
+		hosts := []string{"twitter.com", "www.twitter.com", "mobile.twitter.com", "x.com", "Twitter.com"}
+		suffixes := []string{"", "/", "?s=20", "#frag", "/photo/1", "/video/2?t=abc", "?s=20#frag"}
+		f := func(a, b, c, d uint8) bool {
+			url := prefixes[int(a)%len(prefixes)] + hosts[int(b)%len(hosts)] + "/NetBSDsrc/status/1271800794002710540" + suffixes[int(c)%len(suffixes)]
+			if d%2 == 0 {
+				return localizeURL("npr", url) == "../netbsdsrc/1271800794002710540"
+			}
+			return localizeURL("netbsdsrc", url) == "1271800794002710540"
+		}
+		if err := quick.Check(f, nil); err != nil {
+			t.Error(err)
+		}
+	})
 	t.Run("manually entered unhappy paths", func(t *testing.T) {
 		urls := []string{
 			"https://twitter.com",
@@ -57,6 +91,17 @@ func TestLocalizeURL(t *testing.T) {
 			"https://twitter.com/status/1234",
 			"https://twitter.com/me/status",
 			"https://twitter.com/me/status/",
+			"https://twitter.com/me/likes",
+			"https://twitter.com/me/status/1234/likes",
+			"https://twitter.com/me/status/1234/photo/a",
+			"https://twitter.com/search?q=plan9",
+			"https://twitter.com/hashtag/plan9",
+			"https://twitter.com/i/web/status/x",
+			"https://twitter.com/this_name_is_too_long",
+			"https://twitter.com.example.com/me/status/1234",
+			"https://user@twitter.com/me/status/1234",
+			"ftp://twitter.com/me/status/1234",
+			"https://example.com/me/status/1234",
 		}
 		for _, url := range urls {
 			if got, want := localizeURL("me", url), url; got != want {
@@ -65,3 +110,245 @@ func TestLocalizeURL(t *testing.T) {
 		}
 	})
 }
+
+func TestFormatTweet(t *testing.T) {
+	f, err := newTweetFormat("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tweet := twittergo.Tweet{
+		"id_str":                    "1274574891338129409",
+		"created_at":                "Sun Jun 21 05:00:00 +0000 2020",
+		"full_text":                 "Agreed.\nSee https://t.co/abc",
+		"user":                      map[string]interface{}{"screen_name": "NPR"},
+		"in_reply_to_screen_name":   "netbsdsrc",
+		"in_reply_to_status_id_str": "1271800794002710540",
+		"entities": map[string]interface{}{
+			"urls": []interface{}{
+				map[string]interface{}{"expanded_url": "https://twitter.com/NPR/status/1274574891338129400"},
+				map[string]interface{}{"expanded_url": "https://example.com/"},
+			},
+		},
+		"quoted_status": map[string]interface{}{
+			"id_str":     "1267451982383656961",
+			"created_at": "Mon Jun 01 14:00:00 +0000 2020",
+			"full_text":  "Two\nlines",
+			"user":       map[string]interface{}{"screen_name": "DLangille"},
+		},
+	}
+	got := string(f.formatTweet("npr", tweet, []retweet{{By: "janet", At: time.Date(2020, 6, 22, 0, 0, 0, 0, time.UTC)}}))
+	want := `@npr — 2020-06-21T05:00:00Z — Agreed.
+See https://t.co/abc
+	@dlangille — 2020-06-01T14:00:00Z — Two
+	lines
+Parent: ../netbsdsrc/1271800794002710540
+Quotes: ../dlangille/1267451982383656961
+Link: https://example.com/
+Link: 1274574891338129400
+Retweeted: @janet — 2020-06-22T00:00:00Z
+`
+	if got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestExpandText(t *testing.T) {
+	entity := func(url string, start, end int, fields ...string) map[string]interface{} {
+		e := map[string]interface{}{
+			"url":     url,
+			"indices": []interface{}{float64(start), float64(end)},
+		}
+		for i := 0; i+1 < len(fields); i += 2 {
+			e[fields[i]] = fields[i+1]
+		}
+		return e
+	}
+	// The emoji takes two UTF-16 code units, so it shifts the indices
+	// by two, not one. The entity takes five code units, but it's
+	// decoded only after the edits.
+	text := "😀 &amp; https://t.co/a1 &lt;3 https://t.co/b2 https://t.co/m3"
+	tweet := twittergo.Tweet{
+		"full_text": text,
+		"entities": map[string]interface{}{
+			"urls": []interface{}{
+				entity("https://t.co/a1", 9, 24, "expanded_url", "https://example.com/x?a=1&b=2"),
+				entity("https://t.co/b2", 31, 46, "expanded_url", "https://twitter.com/NPR/status/1274574891338129409"),
+			},
+		},
+		"extended_entities": map[string]interface{}{
+			"media": []interface{}{
+				entity("https://t.co/m3", 47, 62),
+				entity("https://t.co/m3", 47, 62),
+			},
+		},
+	}
+	got := expandText("me", tweet)
+	want := "😀 & https://example.com/x?a=1&b=2 <3 ../npr/1274574891338129409"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	t.Run("misplaced entities are left alone", func(t *testing.T) {
+		tweet := twittergo.Tweet{
+			"full_text": "a &gt; https://t.co/a1",
+			"entities": map[string]interface{}{
+				"urls": []interface{}{
+					entity("https://t.co/a1", 2, 17, "expanded_url", "https://example.com/"),
+				},
+			},
+		}
+		if got, want := expandText("me", tweet), "a > https://t.co/a1"; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	})
+}
+
+func TestTimeFormats(t *testing.T) {
+	f, err := newTweetFormat("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tweet := twittergo.Tweet{
+		"id_str":     "1274574891338129409",
+		"created_at": time.Now().Add(-3*time.Hour - time.Minute).UTC().Format(time.RubyDate),
+		"full_text":  "Hello",
+		"user":       map[string]interface{}{"screen_name": "npr"},
+	}
+	f.relative = true
+	b := f.formatTweet("npr", tweet, nil)
+	if got, want := string(f.expand(b)), "@npr — 3h ago       — Hello\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	// The length stays that of the formatted tweet, for the file's.
+	tweet["created_at"] = "Sun Jun 21 05:00:00 +0000 2020"
+	b = f.formatTweet("npr", tweet, nil)
+	if got, want := string(f.expand(b)), "@npr — 2020-06-21   — Hello\n"; got != want || len(got) != len(b) {
+		t.Errorf("got %q, want %q, of length %d", got, want, len(b))
+	}
+	f.relative = false
+	f.layout = "2006-01-02 15:04 MST"
+	if f.location, err = time.LoadLocation("Asia/Tokyo"); err != nil {
+		t.Skip(err)
+	}
+	tweet["created_at"] = "Sun Jun 21 05:00:00 +0000 2020"
+	if got, want := string(f.formatTweet("npr", tweet, nil)), "@npr — 2020-06-21 14:00 JST — Hello\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestFeedAndMbox(t *testing.T) {
+	fs, cleanup := newTestFileSystem(t, nil, &fsConfig{})
+	defer cleanup()
+	home := fs.root.children["home"]
+	first := home.addTweet(fs.tweets, twittergo.Tweet{
+		"id_str":     "9",
+		"created_at": "Sun Jun 21 05:00:00 +0000 2020",
+		"full_text":  "Fish &amp; chips https://t.co/abc\nFrom the shop",
+		"user":       map[string]interface{}{"screen_name": "Janet", "name": "Janet Doe"},
+		"entities": map[string]interface{}{
+			"urls": []interface{}{
+				map[string]interface{}{"url": "https://t.co/abc", "expanded_url": "https://example.com/", "indices": []interface{}{float64(17), float64(33)}},
+			},
+		},
+	})
+	second := home.addTweet(fs.tweets, twittergo.Tweet{
+		"id_str":                    "10",
+		"created_at":                "Sun Jun 21 06:00:00 +0000 2020",
+		"full_text":                 "@janet Yum",
+		"user":                      map[string]interface{}{"screen_name": "john", "name": "John"},
+		"in_reply_to_screen_name":   "janet",
+		"in_reply_to_status_id_str": "9",
+	})
+
+	// Newest first, compared as numbers.
+	if got, want := string(home.feed()), feedSeparator+string(second.buffer)+feedSeparator+string(first.buffer); got != want {
+		t.Errorf("got feed\n%s\nwant\n%s", got, want)
+	}
+
+	// Oldest first, with the subject as the text shows.
+	want := `From janet@twitter.com Sun Jun 21 05:00:00 2020
+From: "Janet Doe" <janet@twitter.com>
+Date: Sun, 21 Jun 2020 05:00:00 +0000
+Subject: Fish & chips https://example.com/ From the shop
+Message-ID: <9@twitter.com>
+MIME-Version: 1.0
+Content-Type: text/plain; charset=utf-8
+Content-Transfer-Encoding: 8bit
+
+@janet — 2020-06-21T05:00:00Z — Fish & chips https://example.com/
+>From the shop
+Link: https://example.com/
+
+From john@twitter.com Sun Jun 21 06:00:00 2020
+From: "John" <john@twitter.com>
+Date: Sun, 21 Jun 2020 06:00:00 +0000
+Subject: @janet Yum
+Message-ID: <10@twitter.com>
+In-Reply-To: <9@twitter.com>
+References: <9@twitter.com>
+MIME-Version: 1.0
+Content-Type: text/plain; charset=utf-8
+Content-Transfer-Encoding: 8bit
+
+@john — 2020-06-21T06:00:00Z — @janet Yum
+Parent: ../janet/9
+
+`
+	if got := string(home.mbox()); got != want {
+		t.Errorf("got mbox\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestMetadataCounts(t *testing.T) {
+	fake := newFakeTwitter()
+	defer fake.close()
+	fs, cleanup := newTestFileSystem(t, fake.client(), &fsConfig{Metadata: true})
+	defer cleanup()
+	old := fake.addTweet("john", "Hello")
+	fake.count(old, "favorite_count", 1)
+	c := mount(t, fs)
+	defer c.Unmount()
+	read := func(path string) string {
+		t.Helper()
+		f, err := c.FOpen(path, p.OREAD)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		b, err := ioutil.ReadAll(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+	ctl := func(cmd string) {
+		t.Helper()
+		f, err := c.FOpen("/home/ctl", p.OWRITE)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		if _, err := f.Write([]byte(cmd + "\n")); err != nil {
+			t.Fatalf("%s: %v", cmd, err)
+		}
+	}
+	hasText := func(path string, text string) {
+		t.Helper()
+		if got := read(path); !strings.Contains(got, text) {
+			t.Errorf("%s: %q not in %q", path, text, got)
+		}
+	}
+
+	hasText("/home/"+old.IdStr(), "likes 1")
+
+	// Newer tweets are shown with their counts, and reloading updates
+	// the counts of the tweets loaded.
+	recent := fake.addTweet("mary", "Hi")
+	fake.count(recent, "retweet_count", 2)
+	fake.count(old, "favorite_count", 2)
+	ctl("newer")
+	hasText("/home/"+recent.IdStr(), "retweets 2")
+	hasText("/home/"+old.IdStr(), "likes 1")
+	ctl("reload")
+	hasText("/home/"+old.IdStr(), "likes 3")
+	hasText("/home/feed", "likes 3")
+}
@@ -1,12 +1,14 @@
 package main
 
 import (
+	"bytes"
 	"fmt"
 	"log"
+	"path/filepath"
 	"regexp"
 	"sort"
-	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/kurrik/oauth1a"
@@ -43,39 +45,132 @@ func respondError(r *srv.Req, err *p.Error) {
 }
 
 type fsOps struct {
+	// Requests are served concurrently, and scheduled posts sent in
+	// the background, all changing the tree.
+	mu sync.Mutex
+
 	client *twittergo.Client
+	config *fsConfig
 	root   *node
 
+	// All tweets known to the file system, shared by the timelines.
+	tweets *tweetStore
+
+	// Rules to hide tweets from timelines.
+	filters *filterSet
+
+	// Which tweets have been read.
+	readState *readState
+
+	// Pending save of the read state, which is batched.
+	readSaveTimer *time.Timer
+
 	//  The batch size determines how many tweets to load at a time for a user,
 	// or for the home or mentions timelines.
 	batchSize int
+
+	// Directories of users not followed are evicted after this long
+	// without being accessed. Zero means never.
+	userIdleTime time.Duration
+
+	// Wakes the goroutine sending scheduled posts, when one is added.
+	scheduleWake chan struct{}
+
+	// Names of scheduled posts sent, or moved to drafts, whose files
+	// couldn't be removed. Removing them is retried, and the names
+	// aren't reused meanwhile.
+	unremovedScheduled map[string]bool
 }
 
-func newFileSystemOps(client *twittergo.Client, screenName string) *fsOps {
+func newFileSystemOps(client *twittergo.Client, c *fsConfig) (*fsOps, error) {
+	filters, err := loadFilters(filepath.Join(c.dir, "filters"))
+	if err != nil {
+		return nil, err
+	}
+	readState, err := loadReadState(filepath.Join(c.dir, "read"))
+	if err != nil {
+		return nil, err
+	}
+	format, err := newTweetFormat(filepath.Join(c.dir, "tweet.tmpl"))
+	if err != nil {
+		return nil, err
+	}
+	format.metadata = c.Metadata
+	format.columns = c.WrapColumn
+	if c.Timezone != "" {
+		if format.location, err = time.LoadLocation(c.Timezone); err != nil {
+			return nil, errors.Wrapf(err, "timezone %q", c.Timezone)
+		}
+	}
+	if c.TimeFormat == "relative" {
+		format.relative = true
+	} else if c.TimeFormat != "" {
+		format.layout = c.TimeFormat
+	}
 	fs := new(fsOps)
+	fs.filters = filters
+	fs.readState = readState
 	fs.client = client
+	fs.config = c
 	fs.batchSize = 10
+	fs.tweets = newTweetStore(format, c.MaxTweets, c.MaxTweetBytes)
+	fs.userIdleTime = c.userIdleTime
+	fs.scheduleWake = make(chan struct{}, 1)
+	fs.unremovedScheduled = make(map[string]bool)
 	fs.root = (*node)(nil).addChild("root", 0555|p.DMDIR, rootKind)
 	fs.root.dir.Mtime = uint32(time.Now().Unix())
 	fs.root.dir.Atime = fs.root.dir.Mtime
-	ctl := fs.root.addChild("ctl", 0220, controlKind)
+	ctl := fs.root.addChild("ctl", 0664, controlKind)
 	ctl.dir.Mtime = fs.root.dir.Mtime
 	ctl.dir.Atime = fs.root.dir.Mtime
+	post := fs.root.addChild("post", 0664, postKind)
+	post.dir.Mtime = fs.root.dir.Mtime
+	post.dir.Atime = fs.root.dir.Mtime
+	thread := fs.root.addChild("thread", 0664, threadKind)
+	thread.dir.Mtime = fs.root.dir.Mtime
+	thread.dir.Atime = fs.root.dir.Mtime
+	status := fs.root.addChild("status", 0444, statusKind)
+	status.dir.Mtime = fs.root.dir.Mtime
+	status.dir.Atime = fs.root.dir.Mtime
+	drafts := fs.root.addChild("drafts", 0775|p.DMDIR, draftsKind)
+	drafts.dir.Mtime = fs.root.dir.Mtime
+	drafts.dir.Atime = fs.root.dir.Mtime
+	if err := fs.loadDrafts(drafts); err != nil {
+		return nil, err
+	}
+	outbox := fs.root.addChild("outbox", 0775|p.DMDIR, outboxKind)
+	outbox.dir.Mtime = fs.root.dir.Mtime
+	outbox.dir.Atime = fs.root.dir.Mtime
+	outbox.loaded = true
+	scheduled := fs.root.addChild("scheduled", 0555|p.DMDIR, scheduledKind)
+	scheduled.dir.Mtime = fs.root.dir.Mtime
+	scheduled.dir.Atime = fs.root.dir.Mtime
+	if err := fs.loadScheduled(scheduled); err != nil {
+		return nil, err
+	}
+	filtersNode := fs.root.addChild("filters", 0664, filtersKind)
+	filtersNode.dir.Mtime = fs.root.dir.Mtime
+	filtersNode.dir.Atime = fs.root.dir.Mtime
 	home := fs.root.addChild("home", 0555|p.DMDIR, homeKind)
 	home.dir.Mtime = fs.root.dir.Mtime
 	home.dir.Atime = fs.root.dir.Mtime
+	home.collapseRetweets = c.CollapseRetweets
+	home.addTimelineFiles()
 	mentions := fs.root.addChild("mentions", 0555|p.DMDIR, mentionsKind)
 	mentions.dir.Mtime = fs.root.dir.Mtime
 	mentions.dir.Atime = fs.root.dir.Mtime
+	mentions.addTimelineFiles()
 	users := fs.root.addChild("users", 0555|p.DMDIR, usersKind)
 	users.dir.Mtime = fs.root.dir.Mtime
 	users.dir.Atime = fs.root.dir.Mtime
 	fs.root.prepareDirEntries()
 	fs.root.loaded = true
-	return fs
+	return fs, nil
 }
 
 func (fs *fsOps) Attach(r *srv.Req) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
 	if r.Afid != nil {
 		respondError(r, Enoauth)
 	} else {
@@ -84,51 +179,153 @@ func (fs *fsOps) Attach(r *srv.Req) {
 	}
 }
 
+// Records an access to the node, for the eviction policies.
+func (fs *fsOps) touch(n *node) {
+	switch n.kind {
+	case tweetKind:
+		fs.tweets.touch(n)
+	case mediaDirKind, mediaKind:
+		fs.tweets.touch(n.tweet)
+	case userKind:
+		n.accessed = time.Now()
+	}
+}
+
+// Brings memory usage back within the configured limits. To be called
+// after loading tweets or users.
+func (fs *fsOps) evict() {
+	fs.tweets.evict()
+	if fs.userIdleTime > 0 {
+		fs.root.children["users"].evictIdleUsers(fs.tweets, fs.userIdleTime)
+	}
+}
+
+// Marks the tweet as read, because it was read to the end.
+func (fs *fsOps) markRead(tweet *node) {
+	if fs.readState.markRead(tweet.dir.Name) {
+		fs.saveReadStateLater()
+	}
+}
+
+// How long changes to the read state can go unsaved, so that reading
+// many tweets in a row saves once.
+var readSaveDelay = 10 * time.Second
+
+// Saves the read state after readSaveDelay, unless a save is pending
+// already.
+func (fs *fsOps) saveReadStateLater() {
+	if fs.readSaveTimer != nil {
+		return
+	}
+	fs.readSaveTimer = time.AfterFunc(readSaveDelay, func() {
+		fs.mu.Lock()
+		defer fs.mu.Unlock()
+		fs.readSaveTimer = nil
+		if err := fs.readState.save(); err != nil {
+			log.Printf("Could not save read state: %+v", err)
+		}
+	})
+}
+
+// Runs f without holding the lock on the file system, for calls to
+// Twitter or other servers not to hold up other requests. The caller
+// holds the lock, and can't assume nodes are as they were before.
+func (fs *fsOps) unlocked(f func()) {
+	fs.mu.Unlock()
+	defer fs.mu.Lock()
+	f()
+}
+
+// For nodes removed while loading them, without the lock.
+var errOrphaned = errors.New("node was orphaned while loading")
+
 func (fs *fsOps) ensureLoaded(n *node) error {
 	if n.loaded {
 		return nil
 	}
 	switch n.kind {
-	case homeKind:
-		timeline, err := apiStatusesHomeTimeline(fs.client, fs.batchSize, "", "")
+	case homeKind, mentionsKind, userKind:
+		timeline, err := fs.fetchTimeline(n, "", "")
 		if err != nil {
 			return err
 		}
-		n.addTimeline(timeline)
-		n.loaded = true
-	case mentionsKind:
-		timeline, err := apiStatusesMentionsTimeline(fs.client, fs.batchSize, "", "")
-		if err != nil {
-			return err
-		}
-		n.addTimeline(timeline)
-		n.loaded = true
-	case userKind:
-		timeline, err := apiStatusesUserTimeline(fs.client, n.dir.Name, fs.batchSize, "", "")
-		if err != nil {
-			return err
+		// Loaded by another request meanwhile.
+		if n.loaded {
+			return nil
 		}
-		n.addTimeline(timeline)
+		n.addTimeline(fs.tweets, fs.filters, timeline)
 		n.loaded = true
+		fs.evict()
 	case usersKind:
-		followed, err := apiFriendsList(fs.client)
+		var followed []twitterUser
+		var err error
+		fs.unlocked(func() {
+			followed, err = apiFriendsList(fs.client)
+		})
 		if err != nil {
 			return err
 		}
+		stillFollowed := make(map[string]bool)
 		for _, u := range followed {
 			// The check is for when the loaded flag is reset to false via the control file.
 			// We may already know about this user.
-			if _, ok := n.children[u.ScreenName]; !ok {
-				n.addUser(u)
+			child, ok := n.children[u.ScreenName]
+			if !ok {
+				child = n.addUser(u)
+			}
+			child.followed = true
+			stillFollowed[u.ScreenName] = true
+		}
+		// Users unfollowed since the last load, e.g., on the web.
+		for name, child := range n.children {
+			if child.followed && !stillFollowed[name] {
+				n.removeUser(fs.tweets, child)
 			}
 		}
 		n.prepareDirEntries()
 		n.loaded = true
+	case mediaDirKind:
+		fs.statMedia(n)
+		n.loaded = true
+	case unreadKind, feedKind, mboxKind:
+		return fs.ensureLoaded(n.parent)
+	case postKind:
+		return sendOnce(n, func() error { return fs.sendPost(n) })
+	case threadKind:
+		return sendOnce(n, func() error { return fs.sendThread(n) })
+	case mediaKind:
+		url := n.url
+		var b []byte
+		var err error
+		fs.unlocked(func() {
+			b, err = httpGet(url)
+		})
+		if err != nil {
+			return err
+		}
+		// Not to count the media twice, or after its tweet was
+		// dropped, which discounted it.
+		if n.loaded {
+			return nil
+		}
+		if n.kind == orphanedKind {
+			return errOrphaned
+		}
+		n.buffer = b
+		n.dir.Length = uint64(len(b))
+		n.loaded = true
+		// Only media of stored tweets count against the budget.
+		if fs.tweets.holds(n.tweet) {
+			fs.tweets.grow(len(b))
+			fs.evict()
+		}
 	}
 	return nil
 }
 
 func (fs *fsOps) Walk(r *srv.Req) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
 	var walked []p.Qid
 	n := r.Fid.Aux.(*node)
 	if n.kind == orphanedKind {
@@ -143,6 +340,7 @@ func (fs *fsOps) Walk(r *srv.Req) {
 			return
 		} else if child != nil {
 			n = child
+			fs.touch(n)
 			walked = append(walked, n.dir.Qid)
 		}
 	}
@@ -158,10 +356,18 @@ func (fs *fsOps) Walk(r *srv.Req) {
 
 func (fs *fsOps) walkdd(parent *node) (child *node, err *p.Error) {
 	switch parent.kind {
-	case homeKind, mentionsKind, usersKind:
+	case homeKind, mentionsKind, usersKind, draftsKind, outboxKind, scheduledKind:
 		return fs.root, nil
 	case userKind:
 		return fs.root.children["users"], nil
+	case unreadKind:
+		return parent.parent, nil
+	case mediaDirKind:
+		// Any of the timelines listing the tweet will do.
+		for dir := range parent.tweet.parents {
+			return dir, nil
+		}
+		return fs.root, nil
 	default:
 		log.Printf("fixme: walkdd() for node of kind %v", parent.kind)
 		return nil, srv.Enoent
@@ -178,9 +384,22 @@ func (fs *fsOps) walk1(parent *node, childName string) (child *node, err *p.Erro
 	if childName == ".." {
 		return fs.walkdd(parent)
 	}
+	if child, ok := parent.files[childName]; ok {
+		return child, nil
+	}
+	if parent.kind == unreadKind {
+		fs.readState.refresh(parent)
+	}
 	if child, ok := parent.children[childName]; ok {
 		return child, nil
 	}
+	switch parent.kind {
+	case unreadKind:
+		// Only tweets already in the timeline can be unread.
+		return nil, srv.Enoent
+	case draftsKind, outboxKind, scheduledKind:
+		return nil, srv.Enoent
+	}
 	if cerr, ok := parent.errors[childName]; ok {
 		if time.Until(cerr.until) < 0 {
 			return nil, cerr.err
@@ -188,51 +407,159 @@ func (fs *fsOps) walk1(parent *node, childName string) (child *node, err *p.Erro
 			delete(parent.errors, childName)
 		}
 	}
+	if idStr := strings.TrimSuffix(childName, mediaSuffix); idStr != childName && parent.kind != usersKind {
+		tweet, err := fs.walk1(parent, idStr)
+		if tweet == nil || err != nil {
+			return nil, err
+		}
+		if tweet.media == nil {
+			return nil, srv.Enoent
+		}
+		return tweet.media, nil
+	}
 	if parent.kind == usersKind {
-		if user, err := apiUsersShow(fs.client, childName); err != nil {
-			return nil, parent.cacheErrorResponse(childName, err)
-		} else {
+		if user, ok := fs.tweets.user(childName); ok {
 			return parent.addUser(user), nil
 		}
+		var user twitterUser
+		var apiErr error
+		fs.unlocked(func() {
+			user, apiErr = apiUsersShow(fs.client, childName)
+		})
+		if apiErr != nil {
+			return nil, parent.cacheErrorResponse(childName, apiErr)
+		}
+		// Another request may have added the user meanwhile, or the
+		// name walked to may differ in case from the user's.
+		if child, ok := parent.children[strings.ToLower(user.ScreenName)]; ok {
+			return child, nil
+		}
+		return parent.addUser(user), nil
 	}
-	if !idStrExpr.MatchString(childName) {
+	// Only timelines list tweets, e.g., not the root or media
+	// directories.
+	if !parent.isTimeline() || !idStrExpr.MatchString(childName) {
 		return nil, nil
 	}
-	if tweet, err := apiStatusesShow(fs.client, childName); err != nil {
-		return nil, parent.cacheErrorResponse(childName, err)
-	} else {
-		return parent.addTweet(tweet), nil
+	// The tweet may have been fetched already for another timeline.
+	// Tweets the timeline's filters hide can be walked to, but aren't
+	// linked into it, not to be listed.
+	name := parent.timelineName()
+	if tweet := fs.tweets.get(childName); tweet != nil {
+		if fs.filters.match(name, tweet.data) == nil {
+			fs.tweets.link(parent, tweet)
+		}
+		return tweet, nil
+	}
+	var tweet twittergo.Tweet
+	var apiErr error
+	fs.unlocked(func() {
+		tweet, apiErr = apiStatusesShow(fs.client, childName)
+	})
+	if apiErr != nil {
+		return nil, parent.cacheErrorResponse(childName, apiErr)
+	}
+	if parent.kind == orphanedKind {
+		return nil, Eorphaned
 	}
+	if fs.filters.match(name, tweet) != nil {
+		return fs.tweets.loose(tweet), nil
+	}
+	child = parent.addTweet(fs.tweets, tweet)
+	fs.evict()
+	return child, nil
 }
 
 func (fs *fsOps) Open(r *srv.Req) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
 	n := r.Fid.Aux.(*node)
 	if n.kind == orphanedKind {
 		respondError(r, Eorphaned)
 		return
 	}
+	// The filters file too, for each open to apply its own writes.
+	if n.kind == postKind || n.kind == threadKind || n.kind == filtersKind {
+		r.Fid.Aux = n.clonePost()
+	}
+	// Editors truncate the file, then write back all the rules.
+	if n.kind == filtersKind && r.Tc.Mode&p.OTRUNC != 0 {
+		r.Fid.Aux.(*node).truncate = true
+	}
+	if n.kind == draftKind && r.Tc.Mode&p.OTRUNC != 0 {
+		if err := fs.truncateDraft(n); err != nil {
+			respondError(r, newEIO(err))
+			return
+		}
+	}
 	r.RespondRopen(&n.dir.Qid, 0)
 }
 
 func (fs *fsOps) Create(r *srv.Req) {
-	respondError(r, Eperm)
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	n := r.Fid.Aux.(*node)
+	dir := r.Tc.Perm&p.DMDIR != 0
+	var child *node
+	var perr *p.Error
+	switch {
+	case n.kind == usersKind && dir:
+		// Making a directory in /users follows the user.
+		if err := fs.ensureLoaded(n); err != nil {
+			respondError(r, newEIO(err))
+			return
+		}
+		user, err := fs.follow(r.Tc.Name)
+		if err != nil {
+			respondError(r, newEIO(err))
+			return
+		}
+		child = user
+	case n.kind == draftsKind && !dir:
+		child, perr = fs.createDraft(n, r.Tc.Name)
+	case n.kind == outboxKind && !dir:
+		child, perr = fs.createOutgoing(n, r.Tc.Name)
+	default:
+		perr = Eperm
+	}
+	if perr != nil {
+		respondError(r, perr)
+		return
+	}
+	r.Fid.Aux = child
+	r.RespondRcreate(&child.dir.Qid, 0)
 }
 
 func (fs *fsOps) Read(r *srv.Req) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
 	n := r.Fid.Aux.(*node)
 	if n.kind == orphanedKind {
 		respondError(r, Eorphaned)
 		return
 	}
+	if (n.kind == postKind || n.kind == threadKind) && !n.loaded && len(n.buffer) > 0 && n.thread == nil {
+		n.pathOffset = int(r.Tc.Offset)
+	}
 	if err := fs.ensureLoaded(n); err != nil {
 		respondError(r, newEIO(err))
 		return
 	}
+	fs.touch(n)
 	// All our files are small.
 	offset := int(r.Tc.Offset)
 	count := int(r.Tc.Count)
+	if n.kind == postKind || n.kind == threadKind {
+		offset -= n.pathOffset
+		if offset < 0 {
+			offset = len(n.buffer)
+		}
+	}
 	switch n.kind {
-	case homeKind, mentionsKind, userKind, usersKind, rootKind:
+	case homeKind, mentionsKind, userKind, usersKind, rootKind, mediaDirKind, unreadKind, draftsKind, outboxKind, scheduledKind:
+		if n.kind == unreadKind && offset == 0 {
+			fs.readState.refresh(n)
+		}
 		// The offset must be the end of one of the dir entries.
 		if offset > 0 {
 			i := sort.SearchInts(n.boundaries, offset)
@@ -255,11 +582,21 @@ func (fs *fsOps) Read(r *srv.Req) {
 			return
 		}
 		r.RespondRread(n.buffer[offset : offset+count])
-	case tweetKind:
-		if offset >= len(n.buffer) {
+	case tweetKind, mediaKind, textKind, controlKind, statusKind, filtersKind, feedKind, mboxKind, postKind, threadKind, draftKind, scheduledPostKind:
+		if offset == 0 {
+			fs.generate(n)
+		}
+		contents := n.buffer
+		if n.kind == tweetKind {
+			contents = fs.tweets.format.expand(contents)
+			if offset+count >= len(contents) {
+				fs.markRead(n)
+			}
+		}
+		if offset >= len(contents) {
 			r.RespondRread(nil)
 		} else {
-			b := n.buffer[offset:]
+			b := contents[offset:]
 			if count >= len(b) {
 				r.RespondRread(b)
 			} else {
@@ -271,141 +608,137 @@ func (fs *fsOps) Read(r *srv.Req) {
 	}
 }
 
+// Prepares the contents of files that change all the time, hence
+// can't be prepared in advance.
+func (fs *fsOps) generate(n *node) {
+	switch n.kind {
+	case controlKind:
+		n.buffer = ctlHelp(n.parent.kind != rootKind)
+	case statusKind:
+		if n.parent.kind == rootKind {
+			n.buffer = fs.status()
+		} else {
+			n.buffer = fs.timelineStatus(n.parent)
+		}
+	case filtersKind:
+		n.buffer = fs.filters.format()
+	case feedKind:
+		n.buffer = fs.tweets.format.expand(n.parent.feed())
+	case mboxKind:
+		n.buffer = fs.tweets.format.expand(n.parent.mbox())
+	}
+}
+
 func (fs *fsOps) Write(r *srv.Req) {
-	ctl := r.Fid.Aux.(*node)
-	if ctl.kind != controlKind {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	n := r.Fid.Aux.(*node)
+	switch n.kind {
+	case controlKind:
+		fs.writeControl(r)
+	case filtersKind:
+		fs.writeFilters(r)
+	case postKind, threadKind:
+		fs.writePost(r)
+	case draftKind:
+		fs.writeDraft(r)
+	default:
 		respondError(r, Eperm)
-		return
 	}
-	var cmd string
-	var args []string
-	if fields := strings.Fields(string(r.Tc.Data[:r.Tc.Count])); len(fields) > 1 {
-		cmd = fields[0]
-		args = fields[1:]
-	} else if len(fields) > 0 {
-		cmd = fields[0]
-	}
-	if cmd == "reply" && len(args) > 1 {
-		idStr := args[0]
-		// Don't use args, just strip the "post" and the separator.
-		if err := apiStatusesUpdate(fs.client, string(r.Tc.Data[6+len(idStr):]), idStr); err != nil {
-			respondError(r, newEIO(err))
-		}
-		r.RespondRwrite(r.Tc.Count)
-	} else if cmd == "post" && len(args) > 0 {
-		// Don't use args, just strip the "post" and the separator.
-		if err := apiStatusesUpdate(fs.client, string(r.Tc.Data[5:]), ""); err != nil {
-			respondError(r, newEIO(err))
-		}
-		r.RespondRwrite(r.Tc.Count)
-	} else if cmd == "reload" {
-		fs.root.loaded = false
-		r.RespondRwrite(r.Tc.Count)
-	} else if cmd == "batch" && len(args) == 1 {
-		size, err := strconv.Atoi(args[0])
-		if err != nil {
-			respondError(r, newEIO(err))
-		} else {
-			fs.batchSize = size
-			r.RespondRwrite(r.Tc.Count)
-		}
-	} else if cmd == "older" && len(args) == 1 {
-		var dest *node
-		if args[0][0] == '@' {
-			dest = fs.root.children["users"].children[args[0][1:]]
-		} else if args[0] == "home" {
-			dest = fs.root.children["home"]
-		} else if args[0] == "mentions" {
-			dest = fs.root.children["mentions"]
-		}
-		if dest == nil {
-			// In particular of args[0] does not start with '@' and is not "mentions".
-			respondError(r, newEIO(srv.Enoent))
-			return
-		}
-		var timeline twittergo.Timeline
-		var err error
-		if args[0][0] == '@' {
-			timeline, err = apiStatusesUserTimeline(fs.client, dest.dir.Name, fs.batchSize, "", dest.minID)
-		} else if args[0] == "home" {
-			timeline, err = apiStatusesHomeTimeline(fs.client, fs.batchSize, "", dest.minID)
-		} else if args[0] == "mentions" {
-			timeline, err = apiStatusesMentionsTimeline(fs.client, fs.batchSize, "", dest.minID)
-		}
-		if err != nil {
-			respondError(r, newEIO(err))
-			return
-		}
-		dest.addTimeline(timeline)
-		r.RespondRwrite(r.Tc.Count)
-	} else if cmd == "newer" && len(args) == 1 {
-		var dest *node
-		if args[0][0] == '@' {
-			dest = fs.root.children["users"].children[args[0][1:]]
-		} else if args[0] == "home" {
-			dest = fs.root.children["home"]
-		} else if args[0] == "mentions" {
-			dest = fs.root.children["mentions"]
-		}
-		if dest == nil {
-			// In particular of args[0] does not start with '@' and is not "mentions".
-			respondError(r, newEIO(srv.Enoent))
-			return
-		}
-		var timeline twittergo.Timeline
-		var err error
-		if args[0][0] == '@' {
-			timeline, err = apiStatusesUserTimeline(fs.client, dest.dir.Name, fs.batchSize, dest.maxID, "")
-		} else if args[0] == "home" {
-			timeline, err = apiStatusesHomeTimeline(fs.client, fs.batchSize, dest.maxID, "")
-		} else if args[0] == "mentions" {
-			timeline, err = apiStatusesMentionsTimeline(fs.client, fs.batchSize, dest.maxID, "")
-		}
-		if err != nil {
+}
+
+// Applies the complete lines written, all or none, keeping the last
+// line until complete, as it may span writes.
+func (fs *fsOps) writeFilters(r *srv.Req) {
+	n := r.Fid.Aux.(*node)
+	data := append(append([]byte(nil), n.partial...), r.Tc.Data[:r.Tc.Count]...)
+	i := bytes.LastIndexByte(data, '\n')
+	if i >= 0 {
+		if err := fs.filters.update(data[:i+1], n.truncate); err != nil {
 			respondError(r, newEIO(err))
 			return
 		}
-		dest.addTimeline(timeline)
-		r.RespondRwrite(r.Tc.Count)
-	} else if cmd == "trim" && len(args) == 2 {
-		desiredLength, err := strconv.Atoi(args[1])
-		if err != nil {
-			respondError(r, newEIO(errors.Errorf("%q: %v", args[1], err)))
-			return
-		}
-		if desiredLength < 0 {
-			respondError(r, newEIO(errors.Errorf("%q: can't trim to negative size", args[1])))
-			return
-		}
-		var dest *node
-		if args[0][0] == '@' {
-			dest = fs.root.children["users"].children[args[0][1:]]
-		} else if args[0] == "home" {
-			dest = fs.root.children["home"]
-		} else if args[0] == "mentions" {
-			dest = fs.root.children["mentions"]
-		}
-		if dest == nil {
-			// In particular of args[0] does not start with '@' and is not "mentions".
-			respondError(r, newEIO(srv.Enoent))
-			return
-		}
-		dest.trim(desiredLength)
-		r.RespondRwrite(r.Tc.Count)
-	} else {
-		respondError(r, Eunknown)
+		n.truncate = false
 	}
+	n.partial = data[i+1:]
+	r.RespondRwrite(r.Tc.Count)
+}
+
+// Applies what's left to apply to the filters on clunk: a last line
+// not ending in a newline, or the truncation, if nothing was written.
+func (fs *fsOps) flushFilters(n *node) error {
+	if len(n.partial) == 0 && !n.truncate {
+		return nil
+	}
+	err := fs.filters.update(n.partial, n.truncate)
+	n.partial = nil
+	n.truncate = false
+	return err
+}
+
+func (fs *fsOps) writeControl(r *srv.Req) {
+	n := r.Fid.Aux.(*node)
+	if err := fs.runCtl(n.parent, string(r.Tc.Data[:r.Tc.Count])); err != nil {
+		respondError(r, err)
+		return
+	}
+	r.RespondRwrite(r.Tc.Count)
 }
 
 func (fs *fsOps) Clunk(r *srv.Req) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	// Post what was written to the post or thread file, if it wasn't
+	// read, or posting wasn't tried already. Files created in the
+	// outbox are posted even if empty. The fid goes regardless, so
+	// failures are reported by keeping the text as a draft. Posts
+	// being sent by a read are left to it.
+	if n, ok := r.Fid.Aux.(*node); ok && (n.kind == postKind || n.kind == threadKind) && !n.loaded && n.thread == nil && !n.sending && (len(n.buffer) > 0 || n.parent.kind == outboxKind) {
+		if err := fs.ensureLoaded(n); err != nil {
+			fs.keepUnsent(n, err)
+		}
+	}
+	if n, ok := r.Fid.Aux.(*node); ok && n.kind == filtersKind {
+		if err := fs.flushFilters(n); err != nil {
+			log.Printf("Could not apply filters written on close: %+v", err)
+		}
+	}
 	r.RespondRclunk()
 }
 
 func (fs *fsOps) Remove(r *srv.Req) {
-	respondError(r, Eperm)
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	n := r.Fid.Aux.(*node)
+	var err error
+	switch {
+	case n.kind == tweetKind && n.author != "" && n.author == fs.ownScreenName():
+		// Removing one of our own tweets deletes it.
+		err = fs.deleteTweet(n.dir.Name)
+	case n.kind == userKind && n.followed:
+		// Removing the directory of a followed user unfollows them.
+		// Users not followed may have directories just because they
+		// were walked to.
+		err = fs.unfollow(n)
+	case n.kind == draftKind:
+		err = fs.removeDraft(n)
+	case n.kind == scheduledPostKind:
+		// Removing a scheduled post cancels it.
+		err = fs.removeScheduled(n)
+	default:
+		respondError(r, Eperm)
+		return
+	}
+	if err != nil {
+		respondError(r, newEIO(err))
+		return
+	}
+	r.RespondRremove()
 }
 
 func (fs *fsOps) Stat(r *srv.Req) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
 	n := r.Fid.Aux.(*node)
 	if n.kind == orphanedKind {
 		respondError(r, Eorphaned)
@@ -415,7 +748,23 @@ func (fs *fsOps) Stat(r *srv.Req) {
 }
 
 func (fs *fsOps) Wstat(r *srv.Req) {
-	respondError(r, Eperm)
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	n := r.Fid.Aux.(*node)
+	switch {
+	case n.kind == draftKind:
+		if err := fs.wstatDraft(n, &r.Tc.Dir); err != nil {
+			respondError(r, err)
+			return
+		}
+	case n.kind == postKind && n.parent.kind == outboxKind:
+		// Files in the outbox are posted as they are. Ignore, e.g.,
+		// touch setting their times.
+	default:
+		respondError(r, Eperm)
+		return
+	}
+	r.RespondRwstat()
 }
 
 func newClient(c *fsConfig) *twittergo.Client {
@@ -432,12 +781,16 @@ func main() {
 	if err != nil {
 		log.Fatalf("%+v", err)
 	}
-	fs := newFileSystemOps(newClient(c), c.ScreenName)
+	fs, err := newFileSystemOps(newClient(c), c)
+	if err != nil {
+		log.Fatalf("%+v", err)
+	}
 	var s srv.Srv
 	s.Dotu = false
 	//s.Debuglevel = srv.DbgPrintFcalls
 	s.Id = "twitter"
 	s.Start(fs)
+	go fs.runScheduler()
 	if err := s.StartNetListener("tcp", c.ListenAddress); err != nil {
 		log.Fatal(err)
 	}
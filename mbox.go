@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/kurrik/twittergo"
+)
+
+// The domain for the made up addresses and message ids in mailboxes.
+const mailDomain = "twitter.com"
+
+// The subject is the beginning of the tweet text, as shown in the body,
+// up to this many runes.
+const subjectLength = 60
+
+func messageID(idStr string) string {
+	return "<" + idStr + "@" + mailDomain + ">"
+}
+
+// Formats the tweet as a message in a mailbox, for reading timelines
+// with mail readers. The body is the formatted tweet. Replies refer to
+// the tweets they reply to, so that mail readers can thread them.
+func formatMessage(tweet twittergo.Tweet, body []byte) []byte {
+	var b bytes.Buffer
+	screenName := strings.ToLower(tweet.User().ScreenName())
+	from := mail.Address{
+		Name:    tweet.User().Name(),
+		Address: screenName + "@" + mailDomain,
+	}
+	date := tweet.CreatedAt()
+	_, _ = fmt.Fprintf(&b, "From %s %s\n", from.Address, date.Format(time.ANSIC))
+	_, _ = fmt.Fprintf(&b, "From: %s\n", from.String())
+	_, _ = fmt.Fprintf(&b, "Date: %s\n", date.Format(time.RFC1123Z))
+	_, _ = fmt.Fprintf(&b, "Subject: %s\n", mime.QEncoding.Encode("utf-8", subject(expandText(screenName, tweet))))
+	_, _ = fmt.Fprintf(&b, "Message-ID: %s\n", messageID(tweet.IdStr()))
+	if idStr, ok := get(tweet, "in_reply_to_status_id_str"); ok {
+		_, _ = fmt.Fprintf(&b, "In-Reply-To: %s\n", messageID(idStr))
+		_, _ = fmt.Fprintf(&b, "References: %s\n", messageID(idStr))
+	}
+	_, _ = fmt.Fprintf(&b, "MIME-Version: 1.0\n")
+	_, _ = fmt.Fprintf(&b, "Content-Type: text/plain; charset=utf-8\n")
+	_, _ = fmt.Fprintf(&b, "Content-Transfer-Encoding: 8bit\n")
+	_, _ = fmt.Fprintf(&b, "\n")
+	for _, line := range strings.SplitAfter(string(body), "\n") {
+		// Quote lines that would be taken for the start of a message.
+		if strings.HasPrefix(strings.TrimLeft(line, ">"), "From ") {
+			b.WriteByte('>')
+		}
+		b.WriteString(line)
+	}
+	if len(body) > 0 && body[len(body)-1] != '\n' {
+		b.WriteByte('\n')
+	}
+	b.WriteByte('\n')
+	return b.Bytes()
+}
+
+func subject(text string) string {
+	text = strings.Join(strings.Fields(text), " ")
+	if runes := []rune(text); len(runes) > subjectLength {
+		return string(runes[:subjectLength]) + "…"
+	}
+	return text
+}
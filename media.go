@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kurrik/twittergo"
+	"github.com/lionkov/go9p/p"
+	"github.com/pkg/errors"
+)
+
+// Media directories are named after the tweet id, with this suffix.
+const mediaSuffix = ".media"
+
+var mediaClient = &http.Client{Timeout: 5 * time.Minute}
+
+// Builds the media directory for the tweet, containing one file per
+// photo, and one file per GIF or video variant, plus an index file.
+// Returns nil if the tweet has no media. Nothing is downloaded here;
+// see ensureLoaded.
+func newMediaDir(tweetNode *node, tweet twittergo.Tweet) *node {
+	media := tweet.ExtendedEntities().Media()
+	if len(media) == 0 {
+		media = tweet.Entities().Media()
+	}
+	if len(media) == 0 {
+		return nil
+	}
+	dir := (*node)(nil).addChild(tweetNode.dir.Name+mediaSuffix, 0555|p.DMDIR, mediaDirKind)
+	dir.tweet = tweetNode
+	addFile := func(name, url, contentType, altText string) {
+		f := dir.addChild(name, 0444, mediaKind)
+		f.tweet = tweetNode
+		f.url = url
+		f.contentType = contentType
+		f.altText = altText
+	}
+	for i, m := range media {
+		prefix := strconv.Itoa(i + 1)
+		altText, _ := m["ext_alt_text"].(string)
+		if url, ok := m["media_url_https"].(string); ok {
+			// For videos and GIFs, this is the thumbnail.
+			addFile(prefix+path.Ext(url), url, "", altText)
+		}
+		info, _ := m["video_info"].(map[string]interface{})
+		variants, _ := info["variants"].([]interface{})
+		for _, v := range variants {
+			variant, ok := v.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			url, _ := variant["url"].(string)
+			if url == "" {
+				continue
+			}
+			contentType, _ := variant["content_type"].(string)
+			name := prefix
+			if bitrate, ok := variant["bitrate"].(float64); ok {
+				name += "-" + strconv.Itoa(int(bitrate))
+			}
+			name += path.Ext(strings.SplitN(url, "?", 2)[0])
+			addFile(name, url, contentType, altText)
+		}
+	}
+	index := dir.addChild("index", 0444, textKind)
+	index.tweet = tweetNode
+	for _, child := range dir.children {
+		child.dir.Mtime = tweetNode.dir.Mtime
+		child.dir.Atime = child.dir.Mtime
+	}
+	dir.dir.Mtime = tweetNode.dir.Mtime
+	dir.dir.Atime = dir.dir.Mtime
+	dir.prepareDirEntries()
+	return dir
+}
+
+// Learns sizes and content types of the media files, without
+// downloading them, and writes the index file. Each line of the index
+// has file name, content type, size, and alt text, separated by tabs.
+// Sizes that can't be learned, e.g., because the server doesn't tell,
+// are left as zero, and the files can still be read.
+func (fs *fsOps) statMedia(dir *node) {
+	var files []*node
+	var urls []string
+	for _, name := range dir.sortedChildNames() {
+		if f := dir.children[name]; f.kind == mediaKind {
+			files = append(files, f)
+			urls = append(urls, f.url)
+		}
+	}
+	lengths := make([]int64, len(urls))
+	contentTypes := make([]string, len(urls))
+	fs.unlocked(func() {
+		for i, url := range urls {
+			var err error
+			lengths[i], contentTypes[i], err = httpHead(url)
+			if err != nil {
+				log.Printf("Could not learn size of %s: %+v", url, err)
+			}
+		}
+	})
+	var index bytes.Buffer
+	for i, f := range files {
+		if lengths[i] > 0 && !f.loaded {
+			f.dir.Length = uint64(lengths[i])
+		}
+		if f.contentType == "" {
+			f.contentType = contentTypes[i]
+		}
+		if f.contentType == "" {
+			f.contentType = mime.TypeByExtension(path.Ext(f.dir.Name))
+		}
+		_, _ = fmt.Fprintf(&index, "%s\t%s\t%d\t%s\n", f.dir.Name, f.contentType, f.dir.Length, f.altText)
+	}
+	f := dir.children["index"]
+	f.buffer = index.Bytes()
+	f.dir.Length = uint64(len(f.buffer))
+	dir.prepareDirEntries()
+}
+
+func httpHead(url string) (length int64, contentType string, err error) {
+	response, err := mediaClient.Head(url)
+	if err != nil {
+		return 0, "", errors.WithStack(err)
+	}
+	_ = response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return 0, "", errors.Errorf("HEAD %s: %s", url, response.Status)
+	}
+	return response.ContentLength, response.Header.Get("Content-Type"), nil
+}
+
+func httpGet(url string) ([]byte, error) {
+	response, err := mediaClient.Get(url)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer func() {
+		_ = response.Body.Close()
+	}()
+	if response.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("GET %s: %s", url, response.Status)
+	}
+	b, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return b, nil
+}
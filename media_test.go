@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/kurrik/twittergo"
+)
+
+func TestStatMedia(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/photo.jpg":
+			w.Header().Set("Content-Type", "image/jpeg")
+			w.Header().Set("Content-Length", "1234")
+		case "/video.mp4":
+			// No length, as for chunked responses.
+			w.Header().Set("Content-Type", "video/mp4")
+			w.(http.Flusher).Flush()
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	fake := newFakeTwitter()
+	defer fake.close()
+	fs, cleanup := newTestFileSystem(t, nil, &fsConfig{})
+	defer cleanup()
+	tweet := fake.addTweet("john", "Look")
+	tweet["extended_entities"] = map[string]interface{}{
+		"media": []interface{}{
+			map[string]interface{}{"media_url_https": server.URL + "/photo.jpg", "ext_alt_text": "A cat"},
+			map[string]interface{}{"media_url_https": server.URL + "/gone.png"},
+			map[string]interface{}{"video_info": map[string]interface{}{
+				"variants": []interface{}{
+					map[string]interface{}{"url": server.URL + "/video.mp4?tag=1", "bitrate": float64(832000)},
+				},
+			}},
+		},
+	}
+	n := fs.root.children["home"].addTweet(fs.tweets, twittergo.Tweet(tweet))
+	dir := n.media
+
+	// Media that can't be sized don't make the directory unwalkable.
+	if err := fs.ensureLoaded(dir); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]uint64{"1.jpg": 1234, "2.png": 0, "3-832000.mp4": 0}
+	for name, length := range want {
+		f := dir.children[name]
+		if f == nil {
+			t.Errorf("%s: missing", name)
+			continue
+		}
+		if f.dir.Length != length {
+			t.Errorf("%s: got length %d, want %d", name, f.dir.Length, length)
+		}
+	}
+	index := string(dir.children["index"].buffer)
+	for _, line := range []string{
+		"1.jpg\timage/jpeg\t1234\tA cat\n",
+		"2.png\timage/png\t0\t\n",
+		"3-832000.mp4\tvideo/mp4\t0\t\n",
+	} {
+		if !strings.Contains(index, line) {
+			t.Errorf("index %q lacks %q", index, line)
+		}
+	}
+}
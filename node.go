@@ -1,12 +1,14 @@
 package main
 
 import (
+	"container/list"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"runtime"
 	"sort"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -40,30 +42,75 @@ func init() {
 type nodeKind int
 
 const (
-	controlKind  nodeKind = iota // /ctl — the control node for sending commands
-	homeKind                     // /home — the home timeline, a listing of tweets
-	mentionsKind                 // /mentions — the tweets that mentioned the authenticated user
-	orphanedKind                 // a tweet that's been trimmed — not linked into the fs
-	rootKind                     // / — the root
-	tweetKind                    // /mentions/1234 or /users/janet/1234 or /home/1234 — a tweet
-	userKind                     // /users/janet — @janet's timeline
-	usersKind                    // /users — user listing, lazily loaded, starting from followed users
+	controlKind       nodeKind = iota // /ctl — the control node for sending commands
+	draftKind                         // /drafts/hello — a draft, saved to disk as it's written
+	draftsKind                        // /drafts — drafts of tweets, and media to attach to them
+	feedKind                          // /home/feed or /users/janet/feed — all tweets of a timeline in one file
+	filtersKind                       // /filters — the rules to hide tweets from timelines
+	homeKind                          // /home — the home timeline, a listing of tweets
+	mediaDirKind                      // /home/1234.media — photos, GIFs, videos attached to a tweet
+	mediaKind                         // /home/1234.media/1.jpg — a media file, downloaded on first read
+	mboxKind                          // /home/mbox or /users/janet/mbox — a timeline as a mailbox
+	mentionsKind                      // /mentions — the tweets that mentioned the authenticated user
+	orphanedKind                      // a tweet that's been trimmed — not linked into the fs
+	outboxKind                        // /outbox — files created here are posted, always empty
+	postKind                          // /post — write the text of a tweet, read back its path
+	rootKind                          // / — the root
+	scheduledKind                     // /scheduled — posts queued to be sent later
+	scheduledPostKind                 // /scheduled/2020-06-21T10:00:00Z — a post to send at that time
+	statusKind                        // /status or /home/status — settings and state, as key value lines
+	textKind                          // /home/1234.media/index — a read-only file with precomputed contents
+	threadKind                        // /thread — write tweets separated by --- lines, read back their paths
+	tweetKind                         // /mentions/1234 or /users/janet/1234 or /home/1234 — a tweet
+	unreadKind                        // /home/unread or /users/janet/unread — the unread tweets of a timeline
+	userKind                          // /users/janet — @janet's timeline
+	usersKind                         // /users — user listing, lazily loaded, starting from followed users
 )
 
 func (k nodeKind) String() string {
 	switch k {
 	case controlKind:
 		return "control"
+	case draftKind:
+		return "draft"
+	case draftsKind:
+		return "drafts"
+	case feedKind:
+		return "feed"
+	case filtersKind:
+		return "filters"
 	case homeKind:
 		return "home-timeline"
+	case mediaDirKind:
+		return "media-directory"
+	case mediaKind:
+		return "media"
+	case mboxKind:
+		return "mbox"
 	case mentionsKind:
 		return "mentions-timeline"
 	case orphanedKind:
 		return "orphaned"
+	case outboxKind:
+		return "outbox"
+	case postKind:
+		return "post"
 	case rootKind:
 		return "root"
+	case scheduledKind:
+		return "scheduled"
+	case scheduledPostKind:
+		return "scheduled-post"
+	case statusKind:
+		return "status"
+	case textKind:
+		return "text"
+	case threadKind:
+		return "thread"
 	case tweetKind:
 		return "tweet"
+	case unreadKind:
+		return "unread"
 	case userKind:
 		return "user-timeline"
 	case usersKind:
@@ -78,6 +125,13 @@ type cachedErr struct {
 	err   *p.Error
 }
 
+// A retweet of a tweet, for timelines that collapse retweets.
+type retweet struct {
+	idStr string // Of the retweet itself.
+	By    string
+	At    time.Time
+}
+
 type node struct {
 	// For all nodes.
 	kind nodeKind
@@ -87,6 +141,14 @@ type node struct {
 	// users node, and user timeline nodes.
 	children map[string]*node
 
+	// For timeline nodes, the files and directories listed besides the
+	// tweets, e.g., unread. Kept apart so that children are all tweets.
+	files map[string]*node
+
+	// For nodes other than tweets (which can be in many directories),
+	// the directory containing the node.
+	parent *node
+
 	// For directory nodes that need to call Twitter APIs, i.e., all
 	// timeline nodes, and the users node. Caches error API responses.
 	// Shells do all sorts of lookups and we don't want to call Twitter
@@ -110,6 +172,83 @@ type node struct {
 	// what to do if requested to load older or newer tweets.
 	minID string
 	maxID string
+
+	// For timeline nodes collapsing retweets, the id of the retweet
+	// by which a tweet was listed, which is its position in the
+	// timeline, as far as loading older or newer tweets is concerned.
+	positions map[string]string
+
+	// For timeline nodes, how many tweets to load at a time, if set
+	// via the directory's control file.
+	batchSize int
+
+	// For tweet nodes, which live in the tweet store: the (lower case)
+	// screen name of the author, and the timeline directories linking
+	// to the node.
+	author  string
+	parents map[*node]struct{}
+
+	// For tweet nodes, the tweet as returned by the API, and the
+	// retweets seen by timelines collapsing retweets.
+	data     twittergo.Tweet
+	retweets []retweet
+
+	// For timeline nodes: list retweeted tweets, once, instead of the
+	// retweets?
+	collapseRetweets bool
+
+	// For tweet nodes, the position in the tweet store's eviction list.
+	lruElem *list.Element
+
+	// For tweet nodes, the tweet quoted, kept in the store for as long
+	// as a tweet quoting it is, and how many tweets quote this one.
+	quoted  *node
+	quoters int
+
+	// For filters file nodes, each open's own, the last line written,
+	// until complete, and whether the lines written are to replace
+	// the rules, because the file was opened with OTRUNC.
+	partial  []byte
+	truncate bool
+
+	// For post nodes, the offset of the first read, which gives the
+	// path of the tweet posted. Reads after writes on the same file
+	// descriptor start past the text written.
+	pathOffset int
+
+	// For thread nodes, the thread being posted.
+	thread *thread
+
+	// For post, thread, draft and scheduled post nodes, whether the
+	// node is being posted, without the lock held. Other requests
+	// mustn't post it again, or change it, meanwhile.
+	sending bool
+
+	// For drafts, held while writing to the file on disk, which is done
+	// without the lock on the file system, to keep writes in order.
+	// Taken with that lock held, never the other way round.
+	fileMu sync.Mutex
+
+	// For scheduled posts, when to send them.
+	due time.Time
+
+	// For user timeline nodes. Directories for users not followed are
+	// evicted after some time without being accessed.
+	followed bool
+	accessed time.Time
+
+	// For tweet nodes with attached photos, GIFs or videos, the media
+	// directory, listed next to the tweet in timeline directories.
+	media *node
+
+	// For media directories and files, the tweet they belong to.
+	tweet *node
+
+	// For media files, where to download the contents from, and
+	// metadata to list in the media directory index.
+	url         string
+	contentType string
+	altText     string
 }
 
 func isNotFound(err error) bool {
@@ -157,6 +296,7 @@ func (n *node) addChild(name string, mode uint32, kind nodeKind) *node {
 		n.children[name] = child
 	}
 	child.kind = kind
+	child.parent = n
 	child.dir.Name = name
 	child.dir.Uid = owner
 	child.dir.Gid = group
@@ -178,40 +318,127 @@ func (n *node) addUser(u twitterUser) *node {
 	child := n.addChild(u.ScreenName, 0555|p.DMDIR, userKind)
 	child.dir.Mtime = u.Mtime()
 	child.dir.Atime = child.dir.Mtime
+	child.accessed = time.Now()
+	child.addTimelineFiles()
 	return child
 }
 
-func (n *node) addTweet(tweet twittergo.Tweet) *node {
-	if n.kind != homeKind && n.kind != mentionsKind && n.kind != userKind {
+// Adds the files every timeline directory has besides the tweets.
+func (n *node) addTimelineFiles() {
+	n.files = make(map[string]*node)
+	add := func(name string, mode uint32, kind nodeKind) {
+		child := (*node)(nil).addChild(name, mode, kind)
+		child.parent = n
+		child.dir.Mtime = n.dir.Mtime
+		child.dir.Atime = n.dir.Mtime
+		n.files[name] = child
+	}
+	add("ctl", 0664, controlKind)
+	add("status", 0444, statusKind)
+	add("unread", 0555|p.DMDIR, unreadKind)
+	add("feed", 0444, feedKind)
+	add("mbox", 0444, mboxKind)
+}
+
+// The separator between tweets in the feed file.
+const feedSeparator = "---------\n"
+
+// Returns the tweets in the timeline, oldest first.
+func (n *node) tweetsByID() []*node {
+	var tweets []*node
+	for _, tweet := range n.children {
+		tweets = append(tweets, tweet)
+	}
+	sort.Slice(tweets, func(a, b int) bool { return idLess(tweets[a].dir.Name, tweets[b].dir.Name) })
+	return tweets
+}
+
+// Concatenates the tweets in the timeline, newest first.
+func (n *node) feed() []byte {
+	var b []byte
+	tweets := n.tweetsByID()
+	for i := len(tweets) - 1; i >= 0; i-- {
+		b = append(b, feedSeparator...)
+		b = append(b, tweets[i].buffer...)
+	}
+	return b
+}
+
+// Formats the tweets in the timeline as a mailbox, oldest first.
+func (n *node) mbox() []byte {
+	var b []byte
+	for _, tweet := range n.tweetsByID() {
+		b = append(b, formatMessage(tweet.data, tweet.buffer)...)
+	}
+	return b
+}
+
+// Timeline directories list tweets: home, mentions, and user
+// directories.
+func (n *node) isTimeline() bool {
+	return n.kind == homeKind || n.kind == mentionsKind || n.kind == userKind
+}
+
+func (n *node) addTweet(store *tweetStore, tweet twittergo.Tweet) *node {
+	if !n.isTimeline() {
 		log.Printf("fixme: addTweet() called for node of kind %v", n.kind)
 		return nil
 	}
-	child := n.addChild(tweet.IdStr(), 0444, tweetKind)
-	child.buffer = formatTweet(n.dir.Name, tweet)
-	child.dir.Length = uint64(len(child.buffer))
-	child.dir.Mtime = uint32(tweet.CreatedAt().Unix())
-	child.dir.Atime = child.dir.Mtime
+	child := store.put(tweet)
+	store.link(n, child)
 	return child
 }
 
-func (n *node) addTimeline(timeline twittergo.Timeline) {
-	if n.kind != homeKind && n.kind != mentionsKind && n.kind != userKind {
+// Returns the name used to refer to the timeline in commands and
+// filter rules: home, mentions, or @user.
+func (n *node) timelineName() string {
+	switch n.kind {
+	case homeKind:
+		return "home"
+	case mentionsKind:
+		return "mentions"
+	case userKind:
+		return "@" + n.dir.Name
+	default:
+		return ""
+	}
+}
+
+// Adds the tweets to the timeline directory, except those hidden by
+// filters, which aren't put in the store either, not to be kept in it
+// with no timeline listing them.
+func (n *node) addTimeline(store *tweetStore, filters *filterSet, timeline twittergo.Timeline) {
+	if !n.isTimeline() {
 		log.Printf("fixme: addTimeline() called for node of kind: %v", n.kind)
 		return
 	}
+	name := n.timelineName()
 	for _, tweet := range timeline {
 		idStr := tweet.IdStr()
-		if n.minID == "" || n.minID > idStr {
-			n.minID = idStr
-		}
-		if n.maxID == "" || n.maxID < idStr {
-			n.maxID = idStr
-		}
+		n.extendIDRange(idStr)
 		// The check is for when the loaded flag is reset to false via the control file.
 		// We may already know about this tweet.
-		if _, ok := n.children[idStr]; !ok {
-			n.addTweet(tweet)
+		if _, ok := n.children[idStr]; ok {
+			// Already listed, but counts may have changed.
+			store.put(tweet)
+			continue
+		}
+		if filters.hides(name, tweet) {
+			continue
 		}
+		if retweeted := retweetedStatus(tweet); n.collapseRetweets && retweeted != nil {
+			// List the original, positioned as its latest retweet.
+			original := n.addTweet(store, retweeted)
+			store.addRetweet(original, tweet)
+			if n.positions == nil {
+				n.positions = make(map[string]string)
+			}
+			if position, ok := n.positions[original.dir.Name]; !ok || idLess(position, idStr) {
+				n.positions[original.dir.Name] = idStr
+			}
+			continue
+		}
+		n.addTweet(store, tweet)
 	}
 	n.prepareDirEntries()
 }
@@ -220,24 +447,94 @@ func (n *node) prepareDirEntries() {
 	n.buffer = nil
 	n.boundaries = nil
 	end := 0
-	for _, child := range n.children {
-		dent := p.PackDir(&child.dir, false)
+	add := func(dir *p.Dir) {
+		dent := p.PackDir(dir, false)
 		n.buffer = append(n.buffer, dent...)
 		end += len(dent)
 		n.boundaries = append(n.boundaries, end)
 	}
+	for _, child := range n.files {
+		add(&child.dir)
+	}
+	for _, child := range n.children {
+		add(&child.dir)
+		// Media directories are walked to from timelines only.
+		if child.media != nil && n.isTimeline() {
+			add(&child.media.dir)
+		}
+	}
+}
+
+func (n *node) sortedChildNames() []string {
+	var names []string
+	for name := range n.children {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
 }
 
-type byModified []*node
+// Recomputes the range of loaded tweets from the children, after some
+// were removed.
+func (n *node) resetIDRange() {
+	n.minID = ""
+	n.maxID = ""
+	for idStr := range n.children {
+		n.extendIDRange(n.position(idStr))
+	}
+}
+
+func (n *node) extendIDRange(idStr string) {
+	if n.minID == "" || idLess(idStr, n.minID) {
+		n.minID = idStr
+	}
+	if n.maxID == "" || idLess(n.maxID, idStr) {
+		n.maxID = idStr
+	}
+}
 
-func (nodes byModified) Len() int { return len(nodes) }
+// The position of the listed tweet in the timeline: the id of the
+// retweet it was listed by, for timelines collapsing retweets, or its
+// own id.
+func (n *node) position(idStr string) string {
+	if position, ok := n.positions[idStr]; ok {
+		return position
+	}
+	return idStr
+}
 
-func (nodes byModified) Less(a, b int) bool { return nodes[a].dir.Mtime > nodes[b].dir.Mtime }
+// Removes the directories of users not followed that haven't been
+// accessed for the given duration, unlinking their tweets.
+func (n *node) evictIdleUsers(store *tweetStore, idle time.Duration) {
+	if n.kind != usersKind {
+		log.Printf("fixme: evictIdleUsers() called for node of kind: %v", n.kind)
+		return
+	}
+	evicted := false
+	for _, user := range n.children {
+		if user.followed || time.Since(user.accessed) < idle {
+			continue
+		}
+		n.removeUser(store, user)
+		evicted = true
+	}
+	if evicted {
+		n.prepareDirEntries()
+	}
+}
 
-func (nodes byModified) Swap(a, b int) { nodes[a], nodes[b] = nodes[b], nodes[a] }
+// Removes a user directory, unlinking its tweets. The caller prepares
+// the directory entries.
+func (n *node) removeUser(store *tweetStore, user *node) {
+	for _, tweet := range user.children {
+		store.unlink(user, tweet)
+	}
+	user.kind = orphanedKind
+	delete(n.children, user.dir.Name)
+}
 
-func (n *node) trim(size int) {
-	if n.kind != homeKind && n.kind != mentionsKind && n.kind != userKind {
+func (n *node) trim(store *tweetStore, size int) {
+	if !n.isTimeline() {
 		log.Printf("fixme: trim() called for node of kind: %v", n.kind)
 		return
 	}
@@ -245,7 +542,9 @@ func (n *node) trim(size int) {
 		return
 	}
 	if size == 0 {
-		n.children = make(map[string]*node)
+		for _, tweet := range n.children {
+			store.unlink(n, tweet)
+		}
 		n.minID = ""
 		n.maxID = ""
 		n.prepareDirEntries()
@@ -255,11 +554,14 @@ func (n *node) trim(size int) {
 	for _, tweet := range n.children {
 		tweets = append(tweets, tweet)
 	}
-	sort.Sort(byModified(tweets))
-	n.minID = tweets[size-1].dir.Name
+	// Newest first, by position, as that's what loading older tweets
+	// goes by.
+	sort.Slice(tweets, func(a, b int) bool {
+		return idLess(n.position(tweets[b].dir.Name), n.position(tweets[a].dir.Name))
+	})
 	for i := size; i < len(tweets); i++ {
-		tweets[i].kind = orphanedKind
-		delete(n.children, tweets[i].dir.Name)
+		store.unlink(n, tweets[i])
 	}
+	n.resetIDRange()
 	n.prepareDirEntries()
 }
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"log"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/kurrik/twittergo"
+	"github.com/lionkov/go9p/p"
+	"github.com/lionkov/go9p/p/srv"
+	"github.com/pkg/errors"
+)
+
+var (
+	Eposted  = &p.Error{Err: "already posted", Errornum: p.EPERM}
+	Esending = &p.Error{Err: "being posted", Errornum: p.EPERM}
+)
+
+// A tweet to post. In files, i.e., the post and thread files, drafts
+// and the outbox, its text can be preceded by a header and a blank
+// line. The header can give the tweet replied to and the tweet quoted,
+// by id or path, and attach media, with lines giving the path of a
+// file, absolute or relative to the drafts directory, each possibly
+// followed by one giving its alt text:
+//
+//	Reply-To: /users/john/1274574891338129409
+//	Media: /usr/janet/cat.jpg
+//	Alt: A cat asleep on a keyboard
+//
+//	Look who's helping.
+//
+// Text posted via the control file has no header, so that tweets like
+// "Quote: be kind" are posted as they are.
+type outgoing struct {
+	text    string
+	inReply string
+	quote   string
+	media   []attachment
+}
+
+var headerExpr = regexp.MustCompile(`^([A-Za-z-]+):[ \t]*(.*?)\s*$`)
+
+var headerNames = map[string]bool{"reply-to": true, "quote": true, "media": true, "alt": true}
+
+// Parses the header, if the text starts with lines of known header
+// names followed by a blank line, and the text after it. Otherwise,
+// the text is taken as it is. A text starting with a blank line has
+// an empty header, see literal.
+func parseOutgoing(text string) (*outgoing, error) {
+	o := &outgoing{text: strings.TrimRight(text, "\n")}
+	lines := strings.Split(text, "\n")
+	end := -1
+	for i, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			end = i
+			break
+		}
+		if m := headerExpr.FindStringSubmatch(line); m == nil || !headerNames[strings.ToLower(m[1])] {
+			return o, nil
+		}
+	}
+	if end == -1 {
+		return o, nil
+	}
+	for _, line := range lines[:end] {
+		m := headerExpr.FindStringSubmatch(line)
+		switch strings.ToLower(m[1]) {
+		case "reply-to":
+			idStr, err := tweetRef(m[2])
+			if err != nil {
+				return nil, err
+			}
+			o.inReply = idStr
+		case "quote":
+			idStr, err := tweetRef(m[2])
+			if err != nil {
+				return nil, err
+			}
+			o.quote = idStr
+		case "media":
+			o.media = append(o.media, attachment{path: m[2]})
+		case "alt":
+			if len(o.media) == 0 {
+				return nil, errors.New("alt text before any media")
+			}
+			o.media[len(o.media)-1].alt = m[2]
+		}
+	}
+	o.text = strings.TrimRight(strings.Join(lines[end+1:], "\n"), "\n")
+	return o, nil
+}
+
+// Returns the text as it'd be written to a file to be posted as it is:
+// after a blank line, for an empty header, if it'd otherwise be taken
+// for one.
+func literal(text string) string {
+	if o, err := parseOutgoing(text); err != nil || o.text != strings.TrimRight(text, "\n") {
+		return "\n" + text
+	}
+	return text
+}
+
+// Returns the id of a tweet given by id or by path, e.g.,
+// /users/janet/1274574891338129409.
+func tweetRef(s string) (string, error) {
+	if idStr := path.Base(s); idStrExpr.MatchString(idStr) {
+		return idStr, nil
+	}
+	return "", errors.Errorf("%q: not a tweet id or path", s)
+}
+
+// Posts a tweet, or a reply if inReply is set, and adds it to the
+// authenticated user's timeline. The text is posted as it is, without
+// a header.
+func (fs *fsOps) postTweet(text string, inReply string) (*node, error) {
+	return fs.send(&outgoing{text: text, inReply: inReply})
+}
+
+// Posts the text of a file, which may have a header.
+func (fs *fsOps) postFile(text string) (*node, error) {
+	o, err := parseOutgoing(text)
+	if err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(o.text) == "" && len(o.media) == 0 {
+		return nil, errors.New("nothing to post")
+	}
+	return fs.send(o)
+}
+
+// The URL of a tweet, to quote it. Twitter redirects to the right one
+// if the author isn't known.
+func (fs *fsOps) tweetURL(idStr string) string {
+	if tweet := fs.tweets.get(idStr); tweet != nil && tweet.author != "" {
+		return "https://twitter.com/" + tweet.author + "/status/" + idStr
+	}
+	return "https://twitter.com/i/web/status/" + idStr
+}
+
+// Uploads the media of the tweet, if any, then posts it. The lock is
+// released for the uploads and the post.
+func (fs *fsOps) send(o *outgoing) (*node, error) {
+	attachments := make([]attachment, len(o.media))
+	for i, a := range o.media {
+		if !filepath.IsAbs(a.path) {
+			a.path = filepath.Join(fs.draftsDir(), a.path)
+		}
+		attachments[i] = a
+	}
+	var quoteURL string
+	if o.quote != "" {
+		quoteURL = fs.tweetURL(o.quote)
+	}
+	var tweet twittergo.Tweet
+	var err error
+	fs.unlocked(func() {
+		var mediaIDs []string
+		for _, a := range attachments {
+			var mediaID string
+			if mediaID, err = uploadAttachment(fs.client, a); err != nil {
+				return
+			}
+			mediaIDs = append(mediaIDs, mediaID)
+		}
+		tweet, err = apiStatusesUpdate(fs.client, o.text, o.inReply, quoteURL, mediaIDs)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return fs.addOwnTweet(tweet), nil
+}
+
+// Posts the node via send, unless another request is posting it
+// already, which it would post twice while the lock is released.
+func sendOnce(n *node, send func() error) error {
+	if n.sending {
+		return Esending
+	}
+	n.sending = true
+	defer func() { n.sending = false }()
+	return send()
+}
+
+// Adds a tweet just posted to its author's directory, creating the
+// directory if needed, without waiting for the timeline to be loaded
+// again.
+func (fs *fsOps) addOwnTweet(tweet twittergo.Tweet) *node {
+	users := fs.root.children["users"]
+	name := strings.ToLower(tweet.User().ScreenName())
+	dir, ok := users.children[name]
+	if !ok {
+		createdAt, _ := tweet.User()["created_at"].(string)
+		dir = users.addUser(twitterUser{ScreenName: name, CreatedAt: createdAt})
+		users.prepareDirEntries()
+	}
+	child := dir.addTweet(fs.tweets, tweet)
+	dir.prepareDirEntries()
+	fs.evict()
+	return child
+}
+
+// The absolute path of a tweet, in its author's directory.
+func tweetPath(tweet *node) string {
+	return "/users/" + tweet.author + "/" + tweet.dir.Name
+}
+
+// Each open of the post or thread file gets its own node, like Plan 9
+// clone files. What's written to it is posted on the first read, which
+// returns the path of the new tweets, or on clunk, if not read.
+func (n *node) clonePost() *node {
+	clone := (*node)(nil).addChild(n.dir.Name, n.dir.Mode, n.kind)
+	clone.parent = n.parent
+	clone.dir = n.dir
+	return clone
+}
+
+// Keeps the text of a post that failed to send on close, when the
+// error can't be returned, as a draft named like
+// unsent-2020-06-21T08:00:00Z. Threads are kept only if none of their
+// tweets was posted, not to post those twice.
+func (fs *fsOps) keepUnsent(n *node, err error) {
+	log.Printf("Could not post %s on close: %+v", n.dir.Name, err)
+	if strings.TrimSpace(string(n.buffer)) == "" || n.thread != nil && len(n.thread.posted) > 0 {
+		return
+	}
+	draft, err := fs.saveAsDraft("unsent-"+time.Now().UTC().Format(time.RFC3339), n.buffer)
+	if err != nil {
+		log.Printf("Could not keep unsent post as draft: %+v", err)
+		return
+	}
+	log.Printf("Kept unsent post as draft %s", draft.dir.Name)
+}
+
+func (fs *fsOps) sendPost(n *node) error {
+	var tweet *node
+	var err error
+	switch {
+	case strings.TrimSpace(string(n.buffer)) != "":
+		tweet, err = fs.postFile(string(n.buffer))
+	case n.parent.kind == outboxKind:
+		// An empty file created in the outbox sends the draft of
+		// the same name.
+		tweet, err = fs.sendDraft(n.dir.Name)
+	default:
+		return errors.New("nothing to post")
+	}
+	if err != nil {
+		return err
+	}
+	n.buffer = []byte(tweetPath(tweet) + "\n")
+	n.dir.Length = uint64(len(n.buffer))
+	n.loaded = true
+	return nil
+}
+
+func (fs *fsOps) writePost(r *srv.Req) {
+	n := r.Fid.Aux.(*node)
+	if n.loaded || n.thread != nil {
+		respondError(r, Eposted)
+		return
+	}
+	if n.sending {
+		respondError(r, Esending)
+		return
+	}
+	// Writes past the end append. The offset is compared before the
+	// conversion, which may overflow.
+	offset := len(n.buffer)
+	if r.Tc.Offset < uint64(offset) {
+		offset = int(r.Tc.Offset)
+	}
+	n.buffer = append(n.buffer[:offset], r.Tc.Data[:r.Tc.Count]...)
+	n.dir.Length = uint64(len(n.buffer))
+	r.RespondRwrite(r.Tc.Count)
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/lionkov/go9p/p"
+)
+
+func TestPost(t *testing.T) {
+	fake := newFakeTwitter()
+	defer fake.close()
+	fs, cleanup := newTestFileSystem(t, fake.client(), &fsConfig{})
+	defer cleanup()
+
+	// What's written to a clone of the post file is posted on read.
+	post := fs.root.children["post"].clonePost()
+	post.buffer = []byte("Hello, world\n")
+	if err := fs.ensureLoaded(post); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	path := strings.TrimSuffix(string(post.buffer), "\n")
+	if !strings.HasPrefix(path, "/users/janet/") {
+		t.Fatalf("got path %q", path)
+	}
+	user := fs.root.children["users"].children["janet"]
+	if user == nil {
+		t.Fatal("no user directory")
+	}
+	idStr := strings.TrimPrefix(path, "/users/janet/")
+	tweet := user.children[idStr]
+	if tweet == nil {
+		t.Fatalf("%s not in the user directory", idStr)
+	}
+	if got := string(tweet.buffer); !strings.Contains(got, "Hello, world") {
+		t.Errorf("got tweet %q", got)
+	}
+	if got := fake.tweets[idStr].FullText(); got != "Hello, world" {
+		t.Errorf("posted %q", got)
+	}
+	// Each open gets its own clone.
+	if other := fs.root.children["post"].clonePost(); other.loaded || len(other.buffer) != 0 {
+		t.Errorf("clone not fresh")
+	}
+	empty := fs.root.children["post"].clonePost()
+	empty.buffer = []byte("\n")
+	if err := fs.ensureLoaded(empty); err == nil {
+		t.Error("posted nothing")
+	}
+
+	// Tweets posted via the control file land in the same directory.
+	if err := fs.runCtl(fs.root, "post Second\nreply "+idStr+" Third"); err != nil {
+		t.Fatal(err)
+	}
+	if len(user.children) != 3 {
+		t.Errorf("got %d tweets, want 3", len(user.children))
+	}
+	for _, tweet := range user.children {
+		if tweet.data.FullText() == "Third" {
+			if got, _ := get(tweet.data, "in_reply_to_status_id_str"); got != idStr {
+				t.Errorf("got reply to %q, want %q", got, idStr)
+			}
+		}
+	}
+	if err := fs.runCtl(fs.root, "reply 1200000000000000000 Lost"); err == nil {
+		t.Error("replied to a missing tweet")
+	}
+}
+
+func TestPostFailingOnClose(t *testing.T) {
+	fake := newFakeTwitter()
+	defer fake.close()
+	fs, cleanup := newTestFileSystem(t, fake.client(), &fsConfig{})
+	defer cleanup()
+	c := mount(t, fs)
+	defer c.Unmount()
+	fake.updatesLeft = -1
+
+	f, err := c.FOpen("/post", p.OWRITE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte("Hello, world\n")); err != nil {
+		t.Fatal(err)
+	}
+	// The fid is clunked even if posting fails, and the text is kept
+	// as a draft.
+	if err := f.Close(); err != nil {
+		t.Errorf("close: %v", err)
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	drafts := fs.root.children["drafts"]
+	if len(drafts.children) != 1 {
+		t.Fatalf("got %d drafts, want 1", len(drafts.children))
+	}
+	for name, draft := range drafts.children {
+		if !strings.HasPrefix(name, "unsent-") || string(draft.buffer) != "Hello, world\n" {
+			t.Errorf("got draft %s: %q", name, draft.buffer)
+		}
+	}
+}
+
+func TestRequestsWhilePosting(t *testing.T) {
+	fake := newFakeTwitter()
+	defer fake.close()
+	fs, cleanup := newTestFileSystem(t, fake.client(), &fsConfig{})
+	defer cleanup()
+	c := mount(t, fs)
+	defer c.Unmount()
+	f, err := c.FOpen("/post", p.ORDWR)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte("Hello")); err != nil {
+		t.Fatal(err)
+	}
+	fake.stall = make(chan struct{})
+	posted := make(chan error, 1)
+	go func() {
+		_, err := f.ReadAt(make([]byte, 100), 0)
+		posted <- err
+	}()
+	<-fake.stall
+
+	// Other requests are served while the post is sent, but the post
+	// can't be changed.
+	if _, err := c.FStat("/drafts"); err != nil {
+		t.Errorf("stat while posting: %v", err)
+	}
+	if _, err := f.WriteAt([]byte("!"), 5); err == nil {
+		t.Error("wrote to a post being sent")
+	}
+	fake.stall <- struct{}{}
+	if err := <-posted; err != nil {
+		t.Fatal(err)
+	}
+	if len(fake.tweets) != 1 {
+		t.Errorf("got %d tweets posted, want 1", len(fake.tweets))
+	}
+	for _, tweet := range fake.tweets {
+		if tweet.FullText() != "Hello" {
+			t.Errorf("got %q posted", tweet.FullText())
+		}
+	}
+}
+
+func TestWritePostAtLargeOffset(t *testing.T) {
+	fs, cleanup := newTestFileSystem(t, nil, &fsConfig{})
+	defer cleanup()
+	c := mount(t, fs)
+	defer c.Unmount()
+	f, err := c.FOpen("/post", p.ORDWR)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Not closed, not to post.
+	for _, offset := range []int64{0, 1 << 40, -1 << 63} {
+		if _, err := f.WriteAt([]byte("a"), offset); err != nil {
+			t.Errorf("writing at %d: %v", offset, err)
+		}
+	}
+	// Writes past the end append.
+	d, err := c.Stat(f.Fid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Length != 3 {
+		t.Errorf("got length %d, want 3", d.Length)
+	}
+}
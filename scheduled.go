@@ -0,0 +1,254 @@
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Scheduled posts are files in /scheduled, named by the time they're
+// due, in RFC 3339 format and UTC, with a suffix like .1 if several are
+// due at the same time. They're saved to $HOME/lib/twitterfs/scheduled,
+// so that they survive restarts, and posted by a goroutine of the
+// server, runScheduler. Removing one cancels it.
+//
+// Posts due while the server was down are sent on start-up, unless
+// more than the grace period late. Those are not sent, but moved to
+// the drafts directory, named like missed-2020-06-21T10:00:00Z, for
+// the user to decide. Posts failing to send are retried every minute
+// until the grace period is over, then moved to drafts just the same.
+// Posts sent, or moved to drafts, leave /scheduled even if their files
+// can't be removed, not to be sent twice. Removing the files is retried
+// every minute, for as long as the server runs.
+
+// How late a scheduled post can be sent, by default.
+const defaultScheduleGrace = time.Hour
+
+// How long to wait before sending again a scheduled post that failed.
+const scheduleRetryInterval = time.Minute
+
+func (fs *fsOps) scheduledDir() string {
+	return filepath.Join(fs.config.dir, "scheduled")
+}
+
+// Returns the time a scheduled post is due, given its name.
+func parseScheduledName(name string) (time.Time, error) {
+	if i := strings.Index(name, "."); i >= 0 {
+		if _, err := strconv.Atoi(name[i+1:]); err != nil {
+			return time.Time{}, errors.Errorf("%q: bad suffix", name)
+		}
+		name = name[:i]
+	}
+	due, err := time.Parse(time.RFC3339, name)
+	return due, errors.WithStack(err)
+}
+
+func (fs *fsOps) addScheduled(dir *node, name string, due time.Time, text []byte) *node {
+	n := dir.addChild(name, 0444, scheduledPostKind)
+	n.buffer = text
+	n.due = due
+	n.dir.Length = uint64(len(text))
+	n.dir.Mtime = uint32(due.Unix())
+	n.dir.Atime = n.dir.Mtime
+	return n
+}
+
+// Adds the scheduled posts saved to disk to the scheduled directory.
+func (fs *fsOps) loadScheduled(dir *node) error {
+	if err := os.MkdirAll(fs.scheduledDir(), 0700); err != nil {
+		return errors.WithStack(err)
+	}
+	infos, err := ioutil.ReadDir(fs.scheduledDir())
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	for _, info := range infos {
+		if !info.Mode().IsRegular() {
+			continue
+		}
+		due, err := parseScheduledName(info.Name())
+		if err != nil {
+			log.Printf("Ignoring %q in %q: %v", info.Name(), fs.scheduledDir(), err)
+			continue
+		}
+		b, err := ioutil.ReadFile(filepath.Join(fs.scheduledDir(), info.Name()))
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		fs.addScheduled(dir, info.Name(), due, b)
+	}
+	dir.prepareDirEntries()
+	dir.loaded = true
+	return nil
+}
+
+// Queues the text to be posted at the given time, as it is, like text
+// posted via the control file.
+func (fs *fsOps) schedule(due time.Time, text string) (*node, error) {
+	if !due.After(time.Now()) {
+		return nil, errors.Errorf("%s: time is in the past", due.Format(time.RFC3339))
+	}
+	if strings.TrimSpace(text) == "" {
+		return nil, errors.New("nothing to post")
+	}
+	dir := fs.root.children["scheduled"]
+	base := due.UTC().Format(time.RFC3339)
+	name := base
+	for i := 1; dir.children[name] != nil || fs.unremovedScheduled[name]; i++ {
+		name = base + "." + strconv.Itoa(i)
+	}
+	if err := ioutil.WriteFile(filepath.Join(fs.scheduledDir(), name), []byte(text), 0600); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	n := fs.addScheduled(dir, name, due, []byte(text))
+	dir.prepareDirEntries()
+	fs.wakeScheduler()
+	return n, nil
+}
+
+func (fs *fsOps) ctlSchedule(_ *node, args []string) error {
+	due, err := time.Parse(time.RFC3339, args[0])
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	_, err = fs.schedule(due, args[1])
+	return err
+}
+
+func (fs *fsOps) removeScheduled(n *node) error {
+	if n.sending {
+		return Esending
+	}
+	if err := os.Remove(filepath.Join(fs.scheduledDir(), n.dir.Name)); err != nil && !os.IsNotExist(err) {
+		return errors.WithStack(err)
+	}
+	delete(n.parent.children, n.dir.Name)
+	n.parent.prepareDirEntries()
+	n.kind = orphanedKind
+	return nil
+}
+
+// Removes a scheduled post that was sent, or moved to drafts, from
+// the scheduled directory, even if its file can't be removed. That's
+// retried by removeUnremovedScheduled.
+func (fs *fsOps) dropScheduled(n *node) {
+	delete(n.parent.children, n.dir.Name)
+	n.parent.prepareDirEntries()
+	n.kind = orphanedKind
+	if err := os.Remove(filepath.Join(fs.scheduledDir(), n.dir.Name)); err != nil && !os.IsNotExist(err) {
+		log.Printf("Could not remove scheduled post %s, will retry: %+v", n.dir.Name, err)
+		fs.unremovedScheduled[n.dir.Name] = true
+	}
+}
+
+// Retries removing the files of scheduled posts already dropped.
+func (fs *fsOps) removeUnremovedScheduled() {
+	for name := range fs.unremovedScheduled {
+		if err := os.Remove(filepath.Join(fs.scheduledDir(), name)); err != nil && !os.IsNotExist(err) {
+			log.Printf("Could not remove scheduled post %s: %+v", name, err)
+			continue
+		}
+		delete(fs.unremovedScheduled, name)
+	}
+}
+
+// Moves a scheduled post that can't be sent any more to the drafts.
+func (fs *fsOps) missScheduled(n *node) error {
+	if _, err := fs.saveAsDraft("missed-"+n.dir.Name, []byte(literal(string(n.buffer)))); err != nil {
+		return err
+	}
+	fs.dropScheduled(n)
+	return nil
+}
+
+// Sends the scheduled posts that are due, oldest first, and moves to
+// the drafts those too late to send. Returns when to check again, or
+// the zero time if nothing is scheduled.
+func (fs *fsOps) sendScheduled(now time.Time) time.Time {
+	grace := fs.config.scheduleGrace
+	if grace == 0 {
+		grace = defaultScheduleGrace
+	}
+	fs.removeUnremovedScheduled()
+	var posts []*node
+	for _, n := range fs.root.children["scheduled"].children {
+		posts = append(posts, n)
+	}
+	sort.Slice(posts, func(a, b int) bool { return posts[a].due.Before(posts[b].due) })
+	var next time.Time
+	later := func(t time.Time) {
+		if next.IsZero() || t.Before(next) {
+			next = t
+		}
+	}
+	for _, n := range posts {
+		// Cancelled while another was being sent.
+		if n.kind == orphanedKind {
+			continue
+		}
+		if n.due.After(now) {
+			later(n.due)
+			continue
+		}
+		if now.Sub(n.due) > grace {
+			log.Printf("Scheduled post %s missed, moving it to drafts", n.dir.Name)
+			if err := fs.missScheduled(n); err != nil {
+				log.Printf("Could not move scheduled post %s to drafts: %+v", n.dir.Name, err)
+				later(now.Add(scheduleRetryInterval))
+			}
+			continue
+		}
+		err := sendOnce(n, func() error {
+			_, err := fs.postTweet(string(n.buffer), "")
+			return err
+		})
+		if err != nil {
+			log.Printf("Could not send scheduled post %s: %+v", n.dir.Name, err)
+			later(now.Add(scheduleRetryInterval))
+			continue
+		}
+		fs.dropScheduled(n)
+	}
+	if len(fs.unremovedScheduled) > 0 {
+		later(now.Add(scheduleRetryInterval))
+	}
+	return next
+}
+
+// Makes the scheduler check the queue again, e.g., because a post was
+// added.
+func (fs *fsOps) wakeScheduler() {
+	select {
+	case fs.scheduleWake <- struct{}{}:
+	default:
+	}
+}
+
+// Sends scheduled posts as they're due, forever.
+func (fs *fsOps) runScheduler() {
+	for {
+		fs.mu.Lock()
+		next := fs.sendScheduled(time.Now())
+		fs.mu.Unlock()
+		var timer *time.Timer
+		var due <-chan time.Time
+		if !next.IsZero() {
+			timer = time.NewTimer(time.Until(next))
+			due = timer.C
+		}
+		select {
+		case <-due:
+		case <-fs.scheduleWake:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
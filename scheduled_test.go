@@ -0,0 +1,149 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSchedule(t *testing.T) {
+	fake := newFakeTwitter()
+	defer fake.close()
+	fs, cleanup := newTestFileSystem(t, fake.client(), &fsConfig{})
+	defer cleanup()
+	scheduled := fs.root.children["scheduled"]
+
+	due := time.Now().Add(time.Hour).Truncate(time.Second).UTC()
+	name := due.Format(time.RFC3339)
+	if err := fs.runCtl(fs.root, "schedule "+name+" Later\nschedule "+name+" At the same time"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.runCtl(fs.root, "schedule 2020-06-21T10:00:00Z Too late"); err == nil {
+		t.Error("scheduled a post in the past")
+	}
+	if err := fs.runCtl(fs.root, "schedule tomorrow Whenever"); err == nil {
+		t.Error("scheduled a post at a bad time")
+	}
+	if got := string(scheduled.children[name].buffer); got != "Later" {
+		t.Errorf("got %q", got)
+	}
+	if scheduled.children[name+".1"] == nil {
+		t.Errorf("second post at %s not listed", name)
+	}
+
+	// Posts due while the server was down are sent, unless they're
+	// too late, and go to drafts instead. Scheduled posts survive
+	// restarts.
+	missed := time.Now().Add(-3 * time.Hour).UTC().Format(time.RFC3339)
+	late := time.Now().Add(-10 * time.Minute).UTC().Format(time.RFC3339)
+	for name, text := range map[string]string{missed: "Missed", late: "Late"} {
+		if err := ioutil.WriteFile(filepath.Join(fs.scheduledDir(), name), []byte(text), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fs, err := newFileSystemOps(fake.client(), fs.config)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	fs.mu.Lock()
+	scheduled = fs.root.children["scheduled"]
+	if len(scheduled.children) != 4 {
+		t.Fatalf("got %d scheduled posts, want 4", len(scheduled.children))
+	}
+	if next := fs.sendScheduled(time.Now()); !next.Equal(due) {
+		t.Errorf("next check at %v, want %v", next, due)
+	}
+	if len(scheduled.children) != 2 || scheduled.children[missed] != nil || scheduled.children[late] != nil {
+		t.Errorf("late posts still scheduled")
+	}
+	if draft := fs.root.children["drafts"].children["missed-"+missed]; draft == nil || string(draft.buffer) != "Missed" {
+		t.Error("missed post not in drafts")
+	}
+	posted := make(map[string]bool)
+	for _, tweet := range fake.tweets {
+		posted[tweet.FullText()] = true
+	}
+	if !posted["Late"] || posted["Missed"] {
+		t.Errorf("got posts %v", posted)
+	}
+
+	// Removing a scheduled post cancels it, and posts failing to send
+	// are retried.
+	if err := fs.removeScheduled(scheduled.children[name+".1"]); err != nil {
+		t.Fatal(err)
+	}
+	fake.updatesLeft = -1
+	now := due.Add(time.Minute)
+	if next := fs.sendScheduled(now); !next.Equal(now.Add(scheduleRetryInterval)) || scheduled.children[name] == nil {
+		t.Errorf("failed post not retried, next check at %v", next)
+	}
+	fake.updatesLeft = 0
+	if next := fs.sendScheduled(now.Add(scheduleRetryInterval)); !next.IsZero() || len(scheduled.children) != 0 {
+		t.Errorf("got %d scheduled posts, next check at %v", len(scheduled.children), next)
+	}
+	for _, tweet := range fake.tweets {
+		posted[tweet.FullText()] = true
+	}
+	if !posted["Later"] || posted["At the same time"] {
+		t.Errorf("got posts %v", posted)
+	}
+	if infos, err := ioutil.ReadDir(fs.scheduledDir()); err != nil || len(infos) != 0 {
+		t.Errorf("got %d files, %v on disk", len(infos), err)
+	}
+	if _, err := os.Stat(filepath.Join(fs.draftsDir(), "missed-"+missed)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestScheduledPostNotRemoved(t *testing.T) {
+	fake := newFakeTwitter()
+	defer fake.close()
+	fs, cleanup := newTestFileSystem(t, fake.client(), &fsConfig{})
+	defer cleanup()
+	scheduled := fs.root.children["scheduled"]
+	due := time.Now().Add(time.Hour).Truncate(time.Second).UTC()
+	n, err := fs.schedule(due, "Once")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A non-empty directory in place of the file can't be removed.
+	path := filepath.Join(fs.scheduledDir(), n.dir.Name)
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(path, "busy"), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	// The post is sent once, and its name isn't reused until the file
+	// is removed.
+	now := due.Add(time.Minute)
+	if next := fs.sendScheduled(now); !next.Equal(now.Add(scheduleRetryInterval)) || len(scheduled.children) != 0 {
+		t.Errorf("got %d scheduled posts, next check at %v", len(scheduled.children), next)
+	}
+	again, err := fs.schedule(due, "Again")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.dir.Name == n.dir.Name {
+		t.Errorf("name %s reused", n.dir.Name)
+	}
+	if err := os.Remove(filepath.Join(path, "busy")); err != nil {
+		t.Fatal(err)
+	}
+	if next := fs.sendScheduled(now.Add(scheduleRetryInterval)); !next.IsZero() {
+		t.Errorf("next check at %v", next)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("file of sent post not removed: %v", err)
+	}
+	posted := make(map[string]int)
+	for _, tweet := range fake.tweets {
+		posted[tweet.FullText()]++
+	}
+	if posted["Once"] != 1 || posted["Again"] != 1 {
+		t.Errorf("got posts %v", posted)
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// Writes the settings and state of the file system, or of a timeline,
+// one per line, as a key followed by a blank and the value, so that
+// scripts can pick what they need, e.g., with awk.
+type statusWriter struct {
+	b bytes.Buffer
+}
+
+func (w *statusWriter) add(key string, value interface{}) {
+	if s := fmt.Sprint(value); s != "" {
+		_, _ = fmt.Fprintf(&w.b, "%s %s\n", key, s)
+	} else {
+		_, _ = fmt.Fprintln(&w.b, key)
+	}
+}
+
+// For the root status file: the configuration, as changed via the
+// control file, and the state of the tweet store.
+func (fs *fsOps) status() []byte {
+	var w statusWriter
+	format := fs.tweets.format
+	timeFormat := format.layout
+	if format.relative {
+		timeFormat = "relative"
+	}
+	w.add("screen_name", fs.config.ScreenName)
+	w.add("listen_address", fs.config.ListenAddress)
+	w.add("batch", fs.batchSize)
+	w.add("max_tweets", fs.tweets.maxTweets)
+	w.add("max_tweet_bytes", fs.tweets.maxBytes)
+	w.add("user_idle_time", fs.userIdleTime)
+	w.add("metadata", format.metadata)
+	w.add("timezone", format.location)
+	w.add("time_format", timeFormat)
+	w.add("wrap_column", format.columns)
+	w.add("tweets", len(fs.tweets.tweets))
+	w.add("tweet_bytes", fs.tweets.size)
+	w.add("users", len(fs.root.children["users"].children))
+	w.add("filters", len(fs.filters.rules))
+	w.add("drafts", len(fs.root.children["drafts"].children))
+	w.add("scheduled", len(fs.root.children["scheduled"].children))
+	return w.b.Bytes()
+}
+
+// For the status file of a timeline directory.
+func (fs *fsOps) timelineStatus(dir *node) []byte {
+	var w statusWriter
+	name := dir.timelineName()
+	unread := 0
+	for idStr := range dir.children {
+		if fs.readState.isUnread(name, idStr) {
+			unread++
+		}
+	}
+	w.add("timeline", name)
+	w.add("batch", fs.timelineBatchSize(dir))
+	w.add("loaded", dir.loaded)
+	w.add("tweets", len(dir.children))
+	w.add("unread", unread)
+	w.add("min_id", dir.minID)
+	w.add("max_id", dir.maxID)
+	w.add("read_marker", fs.readState.markers[name])
+	w.add("collapse_retweets", dir.collapseRetweets)
+	return w.b.Bytes()
+}
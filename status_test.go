@@ -0,0 +1,66 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestStatus(t *testing.T) {
+	fs, cleanup := newTestFileSystem(t, nil, &fsConfig{
+		ScreenName:    "janet",
+		ListenAddress: "localhost:7731",
+		TimeFormat:    "relative",
+	})
+	defer cleanup()
+	home := fs.root.children["home"]
+	if err := fs.runCtl(fs.root, "batch 20\nmetadata on\nmarkread home 1274574891338129409"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.runCtl(home, "batch 5"); err != nil {
+		t.Fatal(err)
+	}
+	parse := func(b []byte) map[string]string {
+		m := make(map[string]string)
+		for _, line := range strings.Split(strings.TrimSuffix(string(b), "\n"), "\n") {
+			fields := strings.SplitN(line, " ", 2)
+			if len(fields) == 1 {
+				fields = append(fields, "")
+			}
+			if _, ok := m[fields[0]]; ok {
+				t.Errorf("duplicate key %q", fields[0])
+			}
+			m[fields[0]] = fields[1]
+		}
+		return m
+	}
+	testCases := []struct {
+		dir   *node
+		key   string
+		value string
+	}{
+		{fs.root, "screen_name", "janet"},
+		{fs.root, "listen_address", "localhost:7731"},
+		{fs.root, "batch", "20"},
+		{fs.root, "metadata", "true"},
+		{fs.root, "timezone", "UTC"},
+		{fs.root, "time_format", "relative"},
+		{fs.root, "tweets", "0"},
+		{home, "timeline", "home"},
+		{home, "batch", "5"},
+		{home, "loaded", "false"},
+		{home, "min_id", ""},
+		{home, "read_marker", "1274574891338129409"},
+		{fs.root.children["mentions"], "batch", "20"},
+	}
+	for _, tc := range testCases {
+		var status map[string]string
+		if tc.dir == fs.root {
+			status = parse(fs.status())
+		} else {
+			status = parse(fs.timelineStatus(tc.dir))
+		}
+		if got, ok := status[tc.key]; !ok || got != tc.value {
+			t.Errorf("%s: %s: got %q, want %q", tc.dir.dir.Name, tc.key, got, tc.value)
+		}
+	}
+}
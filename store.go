@@ -0,0 +1,281 @@
+package main
+
+import (
+	"container/list"
+	"log"
+	"strings"
+
+	"github.com/kurrik/twittergo"
+)
+
+// The tweet store holds exactly one node per tweet, keyed by tweet id.
+// Timeline directories (home, mentions, user timelines) link to the
+// nodes in the store rather than owning copies, so the same tweet seen
+// via different timelines has the same content and the same qid, and
+// is formatted only once.
+//
+// The store can be given a budget, in number of tweets and/or bytes of
+// formatted tweets. When over budget, the least recently accessed
+// tweets are evicted from all timelines.
+type tweetStore struct {
+	tweets map[string]*node
+
+	// Authors of the tweets stored, so their directories can be
+	// created without calling the API. Each is forgotten with the last
+	// of their tweets, so that the budget bounds them too.
+	users map[string]*storedUser
+
+	// Tweet nodes, most recently accessed at the front.
+	lru *list.List
+
+	// Sum of the lengths of the tweet node buffers.
+	size int
+
+	// Zero means no limit.
+	maxTweets int
+	maxBytes  int
+
+	// How tweets are formatted.
+	format *tweetFormat
+}
+
+// A user known to the store, and how many of their tweets it holds.
+type storedUser struct {
+	twitterUser
+	tweets int
+}
+
+func newTweetStore(format *tweetFormat, maxTweets int, maxBytes int) *tweetStore {
+	return &tweetStore{
+		format:    format,
+		tweets:    make(map[string]*node),
+		users:     make(map[string]*storedUser),
+		lru:       list.New(),
+		maxTweets: maxTweets,
+		maxBytes:  maxBytes,
+	}
+}
+
+func (s *tweetStore) get(idStr string) *node {
+	return s.tweets[idStr]
+}
+
+func (s *tweetStore) user(screenName string) (twitterUser, bool) {
+	if u, ok := s.users[screenName]; ok {
+		return u.twitterUser, true
+	}
+	return twitterUser{}, false
+}
+
+// Adds the tweet to the store and returns its node. The tweet is
+// formatted relative to its author's directory, as that's the one
+// directory where it belongs regardless of which timeline it was
+// fetched for. If the tweet was already there, it's updated, as
+// counts of likes, retweets, etc. may have changed.
+func (s *tweetStore) put(tweet twittergo.Tweet) *node {
+	idStr := tweet.IdStr()
+	if n, ok := s.tweets[idStr]; ok {
+		n.data = tweet
+		s.render(n)
+		return n
+	}
+	n := newTweetNode(tweet)
+	if n.author != "" {
+		u, ok := s.users[n.author]
+		if !ok {
+			createdAt, _ := tweet.User()["created_at"].(string)
+			u = &storedUser{twitterUser: twitterUser{ScreenName: n.author, CreatedAt: createdAt}}
+			s.users[n.author] = u
+		}
+		u.tweets++
+	}
+	// Keep the quoted tweet too, so that the Quotes: path in the
+	// formatted tweet resolves without fetching the quoted tweet.
+	if quoted := quotedStatus(tweet); quoted != nil && quoted.IdStr() != "" {
+		n.quoted = s.put(quoted)
+		n.quoted.quoters++
+	}
+	n.lruElem = s.lru.PushFront(n)
+	s.tweets[idStr] = n
+	s.render(n)
+	return n
+}
+
+// Returns a node for the tweet, formatted as if stored, but outside the
+// store, e.g., for a tweet hidden by filters that's walked to by id.
+// The node isn't counted against the budget, and goes once no fid
+// refers to it.
+func (s *tweetStore) loose(tweet twittergo.Tweet) *node {
+	n := newTweetNode(tweet)
+	n.buffer = s.format.formatTweet(n.author, n.data, n.retweets)
+	n.dir.Length = uint64(len(n.buffer))
+	return n
+}
+
+// Reports whether the node is the one the store holds for its tweet.
+func (s *tweetStore) holds(n *node) bool {
+	return s.tweets[n.dir.Name] == n
+}
+
+func newTweetNode(tweet twittergo.Tweet) *node {
+	n := (*node)(nil).addChild(tweet.IdStr(), 0444, tweetKind)
+	n.author = strings.ToLower(tweet.User().ScreenName())
+	n.data = tweet
+	n.parents = make(map[*node]struct{})
+	n.dir.Mtime = uint32(tweet.CreatedAt().Unix())
+	n.dir.Atime = n.dir.Mtime
+	n.media = newMediaDir(n, tweet)
+	return n
+}
+
+// Formats the tweet into its node's buffer.
+func (s *tweetStore) render(n *node) {
+	s.size -= len(n.buffer)
+	n.buffer = s.format.formatTweet(n.author, n.data, n.retweets)
+	s.size += len(n.buffer)
+	if n.dir.Length != uint64(len(n.buffer)) {
+		n.dir.Length = uint64(len(n.buffer))
+		for dir := range n.parents {
+			dir.prepareDirEntries()
+		}
+	}
+}
+
+// Formats all tweets again, e.g., after the template changed.
+func (s *tweetStore) renderAll() {
+	for _, n := range s.tweets {
+		s.render(n)
+	}
+}
+
+// Records that the tweet was retweeted, as per the retweet wrapper,
+// for timelines that list retweeted tweets once.
+func (s *tweetStore) addRetweet(n *node, wrapper twittergo.Tweet) {
+	idStr := wrapper.IdStr()
+	for _, rt := range n.retweets {
+		if rt.idStr == idStr {
+			return
+		}
+	}
+	n.retweets = append(n.retweets, retweet{
+		idStr: idStr,
+		By:    strings.ToLower(wrapper.User().ScreenName()),
+		At:    wrapper.CreatedAt(),
+	})
+	s.render(n)
+}
+
+// Accounts for media downloaded for a tweet in the store.
+func (s *tweetStore) grow(size int) {
+	s.size += size
+}
+
+// Marks the tweet as the most recently accessed.
+func (s *tweetStore) touch(tweet *node) {
+	if tweet.lruElem != nil {
+		s.lru.MoveToFront(tweet.lruElem)
+	}
+}
+
+// Makes the tweet node a child of the given directory.
+func (s *tweetStore) link(dir *node, tweet *node) {
+	dir.children[tweet.dir.Name] = tweet
+	tweet.parents[dir] = struct{}{}
+}
+
+// Removes the tweet node from the given directory. When no directory
+// links to it anymore, nor a stored tweet quotes it, the tweet is
+// dropped from the store and its node is orphaned, so that open fids
+// on it fail gracefully.
+func (s *tweetStore) unlink(dir *node, tweet *node) {
+	if tweet.kind != tweetKind {
+		log.Printf("fixme: unlink() called for node of kind %v", tweet.kind)
+		return
+	}
+	delete(dir.children, tweet.dir.Name)
+	delete(dir.positions, tweet.dir.Name)
+	delete(tweet.parents, dir)
+	if len(tweet.parents) == 0 && tweet.quoters == 0 {
+		s.drop(tweet)
+	}
+}
+
+// Drops the tweet from the store, and the tweet it quotes if nothing
+// else holds it.
+func (s *tweetStore) drop(tweet *node) {
+	if quoted := tweet.quoted; quoted != nil {
+		tweet.quoted = nil
+		quoted.quoters--
+		if quoted.kind == tweetKind && quoted.quoters == 0 && len(quoted.parents) == 0 {
+			s.drop(quoted)
+		}
+	}
+	if u, ok := s.users[tweet.author]; ok {
+		if u.tweets--; u.tweets == 0 {
+			delete(s.users, tweet.author)
+		}
+	}
+	tweet.kind = orphanedKind
+	delete(s.tweets, tweet.dir.Name)
+	s.lru.Remove(tweet.lruElem)
+	tweet.lruElem = nil
+	s.size -= len(tweet.buffer)
+	if tweet.media != nil {
+		for _, f := range tweet.media.children {
+			if f.kind == mediaKind {
+				s.size -= len(f.buffer)
+			}
+			f.kind = orphanedKind
+		}
+		tweet.media.kind = orphanedKind
+	}
+}
+
+func (s *tweetStore) overBudget() bool {
+	if s.maxTweets > 0 && len(s.tweets) > s.maxTweets {
+		return true
+	}
+	return s.maxBytes > 0 && s.size > s.maxBytes
+}
+
+// Evicts the least recently accessed tweets until the store is within
+// budget. The tweets are removed from all the timelines linking them,
+// and the range of loaded tweets of those timelines is adjusted, like
+// trim does.
+func (s *tweetStore) evict() {
+	changed := make(map[*node]struct{})
+	for s.overBudget() && s.lru.Len() > 0 {
+		s.detach(s.lru.Back().Value.(*node), changed)
+	}
+	for dir := range changed {
+		dir.prepareDirEntries()
+	}
+}
+
+// Removes the tweet from the store and from all timelines, e.g.,
+// because it was deleted.
+func (s *tweetStore) remove(tweet *node) {
+	changed := make(map[*node]struct{})
+	s.detach(tweet, changed)
+	for dir := range changed {
+		dir.prepareDirEntries()
+	}
+}
+
+// Unlinks the tweet from all the timelines linking it, adjusting their
+// range of loaded tweets, and drops it. The timelines are added to
+// changed, for the caller to prepare their entries once done.
+func (s *tweetStore) detach(tweet *node, changed map[*node]struct{}) {
+	idStr := tweet.dir.Name
+	for dir := range tweet.parents {
+		position := dir.position(idStr)
+		delete(dir.children, idStr)
+		delete(dir.positions, idStr)
+		if position == dir.minID || position == dir.maxID {
+			dir.resetIDRange()
+		}
+		changed[dir] = struct{}{}
+	}
+	tweet.parents = nil
+	s.drop(tweet)
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/kurrik/twittergo"
+)
+
+func TestTweetStore(t *testing.T) {
+	fake := newFakeTwitter()
+	defer fake.close()
+	fs, cleanup := newTestFileSystem(t, nil, &fsConfig{})
+	defer cleanup()
+	store := fs.tweets
+	home := fs.root.children["home"]
+	mentions := fs.root.children["mentions"]
+
+	// The same tweet in two timelines is the same node.
+	hello := fake.addTweet("john", "Hello")
+	n := home.addTweet(store, hello)
+	if other := mentions.addTweet(store, hello); other != n || store.get(hello.IdStr()) != n {
+		t.Fatal("tweet stored twice")
+	}
+	if len(n.parents) != 2 || home.children[n.dir.Name] != n || mentions.children[n.dir.Name] != n {
+		t.Errorf("got %d parents", len(n.parents))
+	}
+	// Putting it again updates it in place.
+	hello["full_text"] = "Hello, world"
+	if store.put(hello) != n || !strings.Contains(string(n.buffer), "Hello, world") {
+		t.Errorf("got %q after update", n.buffer)
+	}
+	if n.dir.Length != uint64(len(n.buffer)) || store.size != len(n.buffer) {
+		t.Errorf("got length %d, store size %d, want %d", n.dir.Length, store.size, len(n.buffer))
+	}
+
+	// A tweet is dropped when no timeline links it anymore.
+	store.unlink(home, n)
+	if store.get(n.dir.Name) != n || n.kind != tweetKind || home.children[n.dir.Name] != nil {
+		t.Error("tweet dropped while still linked")
+	}
+	if _, ok := store.user("john"); !ok {
+		t.Error("author of stored tweet unknown")
+	}
+	store.unlink(mentions, n)
+	if store.get(n.dir.Name) != nil || n.kind != orphanedKind || store.lru.Len() != 0 || store.size != 0 {
+		t.Error("unlinked tweet not dropped")
+	}
+	// So are authors, with their last tweet.
+	if _, ok := store.user("john"); ok || len(store.users) != 0 {
+		t.Errorf("got %d users known with no tweets stored", len(store.users))
+	}
+
+	// Detaching removes a tweet from all timelines at once, adjusting
+	// their range of loaded tweets.
+	first := fake.addTweet("john", "First")
+	second := fake.addTweet("mary", "Second")
+	home.addTimeline(store, fs.filters, twittergo.Timeline{second, first})
+	mentions.addTimeline(store, fs.filters, twittergo.Timeline{second})
+	changed := make(map[*node]struct{})
+	store.detach(store.get(second.IdStr()), changed)
+	if len(changed) != 2 || home.children[second.IdStr()] != nil || mentions.children[second.IdStr()] != nil {
+		t.Errorf("detached tweet still listed, %d timelines changed", len(changed))
+	}
+	if home.minID != first.IdStr() || home.maxID != first.IdStr() || mentions.minID != "" || mentions.maxID != "" {
+		t.Errorf("got ranges %s-%s and %q-%q", home.minID, home.maxID, mentions.minID, mentions.maxID)
+	}
+
+	// Tweets are walked to from timelines only, even if stored.
+	idStr := first.IdStr()
+	if child, err := fs.walk1(fs.root, idStr); child != nil || err != nil {
+		t.Errorf("walked to %s from the root: %v, %v", idStr, child, err)
+	}
+	if fs.root.children[idStr] != nil {
+		t.Error("tweet linked into the root")
+	}
+	mentions.loaded = true
+	if child, err := fs.walk1(mentions, idStr); child != store.get(idStr) || err != nil {
+		t.Errorf("walked to %v, %v from mentions", child, err)
+	}
+}
+
+func TestQuotedTweetsKeptWhileQuoted(t *testing.T) {
+	fake := newFakeTwitter()
+	defer fake.close()
+	fs, cleanup := newTestFileSystem(t, nil, &fsConfig{})
+	defer cleanup()
+	store := fs.tweets
+	home := fs.root.children["home"]
+	mentions := fs.root.children["mentions"]
+	quoted := fake.addTweet("mary", "Quotable")
+	first := fake.addTweet("john", "Indeed")
+	first["quoted_status"] = map[string]interface{}(quoted)
+	second := fake.addTweet("paul", "Agreed")
+	second["quoted_status"] = map[string]interface{}(quoted)
+
+	one := home.addTweet(store, first)
+	two := mentions.addTweet(store, second)
+	q := store.get(quoted.IdStr())
+	if q == nil || q.quoters != 2 || len(q.parents) != 0 {
+		t.Fatalf("quoted tweet not stored, or not counted: %v", q)
+	}
+	store.unlink(home, one)
+	if store.get(quoted.IdStr()) != q {
+		t.Error("quoted tweet dropped while still quoted")
+	}
+	store.unlink(mentions, two)
+	if store.get(quoted.IdStr()) != nil || q.kind != orphanedKind || len(store.tweets) != 0 || store.lru.Len() != 0 {
+		t.Errorf("got %d tweets left after unlinking all", len(store.tweets))
+	}
+
+	// A quoted tweet also listed in a timeline stays there.
+	one = home.addTweet(store, first)
+	q = store.get(quoted.IdStr())
+	mentions.addTweet(store, quoted)
+	store.unlink(home, one)
+	if store.get(quoted.IdStr()) != q || q.kind != tweetKind || q.quoters != 0 {
+		t.Error("listed quoted tweet dropped")
+	}
+}
+
+func TestCollapseRetweets(t *testing.T) {
+	fake := newFakeTwitter()
+	defer fake.close()
+	fs, cleanup := newTestFileSystem(t, nil, &fsConfig{})
+	defer cleanup()
+	store := fs.tweets
+	home := fs.root.children["home"]
+	home.collapseRetweets = true
+	retweet := func(by string, tweet twittergo.Tweet) twittergo.Tweet {
+		wrapper := fake.addTweet(by, "RT")
+		wrapper["retweeted_status"] = map[string]interface{}(tweet)
+		return wrapper
+	}
+	// Ids of different lengths, so that comparing them as strings
+	// would get the order wrong.
+	fake.nextID = 8
+	old := fake.addTweet("mary", "Old news")
+	plain := fake.addTweet("john", "Hello")
+	first := retweet("paul", old)
+	second := retweet("ringo", old)
+	home.addTimeline(store, fs.filters, twittergo.Timeline{second, first, plain})
+
+	if len(home.children) != 2 || home.children[old.IdStr()] == nil || home.children[plain.IdStr()] == nil {
+		t.Fatalf("got %d tweets listed, want the original and the plain tweet", len(home.children))
+	}
+	if store.get(first.IdStr()) != nil || store.get(second.IdStr()) != nil || len(store.tweets) != 2 {
+		t.Errorf("retweets stored, got %d tweets", len(store.tweets))
+	}
+	if n := store.get(old.IdStr()); len(n.retweets) != 2 {
+		t.Errorf("got %d retweets recorded, want 2", len(n.retweets))
+	}
+	if home.minID != plain.IdStr() || home.maxID != second.IdStr() {
+		t.Errorf("got range %s-%s, want %s-%s", home.minID, home.maxID, plain.IdStr(), second.IdStr())
+	}
+
+	// The range stays in terms of retweet ids after trimming: the
+	// original, retweeted last, is the newest.
+	home.trim(store, 1)
+	if home.children[old.IdStr()] == nil || home.children[plain.IdStr()] != nil {
+		t.Error("trim kept the wrong tweet")
+	}
+	if home.minID != second.IdStr() || home.maxID != second.IdStr() {
+		t.Errorf("got range %s-%s after trim, want %s", home.minID, home.maxID, second.IdStr())
+	}
+
+	// And after eviction.
+	newer := fake.addTweet("john", "Newer")
+	home.addTimeline(store, fs.filters, twittergo.Timeline{newer})
+	store.remove(store.get(newer.IdStr()))
+	if home.minID != second.IdStr() || home.maxID != second.IdStr() {
+		t.Errorf("got range %s-%s after eviction, want %s", home.minID, home.maxID, second.IdStr())
+	}
+	store.remove(store.get(old.IdStr()))
+	if home.minID != "" || home.maxID != "" || len(home.positions) != 0 {
+		t.Errorf("got range %q-%q and %d positions when empty", home.minID, home.maxID, len(home.positions))
+	}
+}
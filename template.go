@@ -0,0 +1,392 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/kurrik/twittergo"
+	"github.com/pkg/errors"
+)
+
+// The built-in template for tweet files. The formatting of the tweet
+// text is quite tentative and subject to change. It can be replaced
+// by a template in $HOME/lib/twitterfs/tweet.tmpl, which is executed
+// with a *tweetView.
+const defaultTweetTemplate = `{{if wrapping -}}
+@{{.ScreenName}} — {{.CreatedAt}}
+{{wrap .Text}}
+{{with .Quoted}}{{indent (printf "@%s — %s\n%s" .ScreenName .CreatedAt (wrap .Text 8))}}
+{{end}}
+{{- else -}}
+@{{.ScreenName}} — {{.CreatedAt}} — {{.Text}}
+{{with .Quoted}}{{indent (printf "@%s — %s — %s" .ScreenName .CreatedAt .Text)}}
+{{end}}
+{{- end}}{{with .Parent}}Parent: {{.}}
+{{end}}{{with .Retweets}}Retweets: {{.}}
+{{end}}{{with .Quotes}}Quotes: {{.}}
+{{end}}{{range .Links}}Link: {{.}}
+{{end}}{{range .RetweetedBy}}Retweeted: @{{.By}} — {{formatTime .At}}
+{{end}}{{if .ShowMetadata}}{{with .Counts}}Counts: {{.}}
+{{end}}{{with .Lang}}Lang: {{.}}
+{{end}}{{with .Source}}Source: {{.}}
+{{end}}{{if .Edited}}Edited: yes
+{{end}}{{if .Sensitive}}Sensitive: yes
+{{end}}{{end}}`
+
+// Functions available to templates, besides those bound to the tweet
+// format (see tweetFormat.funcs).
+var templateFuncs = template.FuncMap{
+	// Prefixes each line with a tab.
+	"indent": func(s string) string {
+		return "\t" + strings.Replace(s, "\n", "\n\t", -1)
+	},
+	"lower": strings.ToLower,
+	"join":  strings.Join,
+}
+
+// For stripping the link around the client name in a tweet's source.
+var htmlTagExpr = regexp.MustCompile(`<[^>]*>`)
+
+// How tweets are formatted into files.
+type tweetFormat struct {
+	// The template file; it may not exist, in which case the built-in
+	// template is used.
+	path string
+	tmpl *template.Template
+
+	// Whether to render the metadata block (counts, language, etc.).
+	metadata bool
+
+	// How to format times: in which time zone, and with which layout.
+	// If relative, times are formatted like "3h ago" when read, rather
+	// than when the tweet is formatted; see expand.
+	location *time.Location
+	layout   string
+	relative bool
+
+	// If positive, where to wrap the text of tweets. The header then
+	// goes on its own line.
+	columns int
+}
+
+func newTweetFormat(path string) (*tweetFormat, error) {
+	f := &tweetFormat{
+		path:     path,
+		location: time.UTC,
+		layout:   time.RFC3339,
+	}
+	if err := f.load(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// (Re)loads the template file, keeping the current template in case of
+// error.
+func (f *tweetFormat) load() error {
+	text := defaultTweetTemplate
+	if f.path != "" {
+		b, err := ioutil.ReadFile(f.path)
+		if err != nil && !os.IsNotExist(err) {
+			return errors.WithStack(err)
+		}
+		if err == nil {
+			text = string(b)
+		}
+	}
+	tmpl, err := template.New("tweet").Funcs(templateFuncs).Funcs(f.funcs()).Parse(text)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	f.tmpl = tmpl
+	return nil
+}
+
+func (f *tweetFormat) funcs() template.FuncMap {
+	return template.FuncMap{
+		// Formats a time as configured, or according to the given
+		// layout, e.g., {{formatTime .Time "Jan 2 15:04"}}.
+		"formatTime": func(t time.Time, layout ...string) string {
+			if len(layout) > 0 {
+				return t.In(f.location).Format(layout[0])
+			}
+			return f.formatTime(t)
+		},
+		// Is wrapping enabled?
+		"wrapping": func() bool {
+			return f.columns > 0
+		},
+		// Wraps text at the configured column, minus the given
+		// indentation, if any, e.g., {{indent (wrap .Text 8)}}.
+		"wrap": func(text string, indent ...int) string {
+			if f.columns <= 0 {
+				return text
+			}
+			columns := f.columns
+			if len(indent) > 0 {
+				columns -= indent[0]
+			}
+			return wrapText(text, columns)
+		},
+	}
+}
+
+// Times in relative format are rendered as markers, which expand
+// replaces with the time elapsed since.
+const timeMarker = '\x00'
+
+func (f *tweetFormat) formatTime(t time.Time) string {
+	if f.relative {
+		return fmt.Sprintf("%c%d%c", timeMarker, t.Unix(), timeMarker)
+	}
+	return t.In(f.location).Format(f.layout)
+}
+
+// Replaces time markers in formatted tweets with how long ago the
+// time was, padded with spaces to the width of the marker, so that the
+// length of tweet files doesn't change as time passes.
+func (f *tweetFormat) expand(b []byte) []byte {
+	if !f.relative || bytes.IndexByte(b, timeMarker) == -1 {
+		return b
+	}
+	now := time.Now()
+	var out []byte
+	for {
+		i := bytes.IndexByte(b, timeMarker)
+		if i == -1 {
+			break
+		}
+		j := bytes.IndexByte(b[i+1:], timeMarker)
+		if j == -1 {
+			break
+		}
+		out = append(out, b[:i]...)
+		var ago string
+		if secs, err := strconv.ParseInt(string(b[i+1:i+1+j]), 10, 64); err == nil {
+			ago = f.ago(time.Unix(secs, 0), now)
+		}
+		width := j + 2
+		if len(ago) > width {
+			ago = ago[:width]
+		}
+		out = append(out, ago...)
+		out = append(out, strings.Repeat(" ", width-len(ago))...)
+		b = b[i+1+j+1:]
+	}
+	return append(out, b...)
+}
+
+func (f *tweetFormat) ago(t time.Time, now time.Time) string {
+	d := now.Sub(t)
+	switch {
+	case d < time.Minute:
+		return "just now"
+	case d < time.Hour:
+		return fmt.Sprintf("%dm ago", d/time.Minute)
+	case d < 24*time.Hour:
+		return fmt.Sprintf("%dh ago", d/time.Hour)
+	case d < 30*24*time.Hour:
+		return fmt.Sprintf("%dd ago", d/(24*time.Hour))
+	default:
+		return t.In(f.location).Format("2006-01-02")
+	}
+}
+
+func (f *tweetFormat) formatTweet(currentUser string, tweet twittergo.Tweet, retweets []retweet) []byte {
+	var text bytes.Buffer
+	view := &tweetView{
+		format:       f,
+		currentUser:  currentUser,
+		Tweet:        tweet,
+		RetweetedBy:  retweets,
+		ShowMetadata: f.metadata,
+	}
+	if err := f.tmpl.Execute(&text, view); err != nil {
+		// Show the problem where the user will see it.
+		_, _ = fmt.Fprintf(&text, "\ntemplate error: %v\n", err)
+	}
+	return text.Bytes()
+}
+
+// What tweet templates are executed with. The raw tweet, as returned
+// by the API, is available as .Tweet, e.g., {{.Tweet.lang}}. Paths
+// are relative to the directory of the current user, normally the
+// author.
+type tweetView struct {
+	format      *tweetFormat
+	currentUser string
+	Tweet       twittergo.Tweet
+
+	// The retweets of the tweet, when retweets are collapsed.
+	RetweetedBy []retweet
+
+	// Whether the metadata block is enabled.
+	ShowMetadata bool
+}
+
+func (v *tweetView) ScreenName() string {
+	return strings.ToLower(v.Tweet.User().ScreenName())
+}
+
+func (v *tweetView) Name() string {
+	return v.Tweet.User().Name()
+}
+
+func (v *tweetView) ID() string {
+	return v.Tweet.IdStr()
+}
+
+func (v *tweetView) Time() time.Time {
+	return v.Tweet.CreatedAt()
+}
+
+// The creation time, formatted as configured.
+func (v *tweetView) CreatedAt() string {
+	return v.format.formatTime(v.Tweet.CreatedAt())
+}
+
+// The text with links expanded and HTML entities decoded.
+func (v *tweetView) Text() string {
+	return expandText(v.currentUser, v.Tweet)
+}
+
+// The text as returned by the API.
+func (v *tweetView) RawText() string {
+	return tweetContent(v.Tweet)
+}
+
+func (v *tweetView) Path() string {
+	return tweetRelativePath(v.currentUser, v.Tweet)
+}
+
+func (v *tweetView) Parent() string {
+	return parentRelativePath(v.currentUser, v.Tweet)
+}
+
+func (v *tweetView) Retweets() string {
+	return retweetedRelativePath(v.currentUser, v.Tweet)
+}
+
+func (v *tweetView) Quotes() string {
+	return quotedRelativePath(v.currentUser, v.Tweet)
+}
+
+// The quoted tweet, or nil.
+func (v *tweetView) Quoted() *tweetView {
+	if quoted := quotedStatus(v.Tweet); quoted != nil {
+		return &tweetView{format: v.format, currentUser: v.currentUser, Tweet: quoted}
+	}
+	return nil
+}
+
+// The retweeted tweet, or nil.
+func (v *tweetView) Retweeted() *tweetView {
+	if retweeted := retweetedStatus(v.Tweet); retweeted != nil {
+		return &tweetView{format: v.format, currentUser: v.currentUser, Tweet: retweeted}
+	}
+	return nil
+}
+
+// Converts tweet URLs to relative paths, see localizeURL.
+func (v *tweetView) Localize(url string) string {
+	return localizeURL(v.currentUser, url)
+}
+
+// The expanded URLs and media URLs in the tweet, localized, sorted.
+func (v *tweetView) Links() []string {
+	// Collect URLs from various parts of the Tweet JSON.
+	urlSet := make(map[string]struct{})
+	collectURLs(urlSet, either{urls: v.Tweet.Entities().URLs()}, "expanded_url")
+	collectURLs(urlSet, either{urls: v.Tweet.ExtendedEntities().URLs()}, "expanded_url")
+	collectURLs(urlSet, either{media: v.Tweet.Entities().Media()}, "media_url_https")
+	collectURLs(urlSet, either{media: v.Tweet.ExtendedEntities().Media()}, "media_url_https")
+	var urlList []string
+	for url := range urlSet {
+		urlList = append(urlList, url)
+	}
+	sort.Strings(urlList)
+	for i, url := range urlList {
+		urlList[i] = v.Localize(url)
+	}
+	return urlList
+}
+
+func (v *tweetView) Hashtags() []string {
+	var tags []string
+	for _, h := range v.Tweet.Entities().Hashtags() {
+		if text, ok := h["text"].(string); ok {
+			tags = append(tags, text)
+		}
+	}
+	return tags
+}
+
+// The mentioned users' screen names, lower case.
+func (v *tweetView) Mentions() []string {
+	var users []string
+	for _, m := range v.Tweet.Entities().UserMentions() {
+		if screenName, ok := m["screen_name"].(string); ok {
+			users = append(users, strings.ToLower(screenName))
+		}
+	}
+	return users
+}
+
+func (v *tweetView) count(fieldName string) (int, bool) {
+	n, ok := v.Tweet[fieldName].(float64)
+	return int(n), ok
+}
+
+func (v *tweetView) RetweetCount() int { n, _ := v.count("retweet_count"); return n }
+
+func (v *tweetView) LikeCount() int { n, _ := v.count("favorite_count"); return n }
+
+func (v *tweetView) ReplyCount() int { n, _ := v.count("reply_count"); return n }
+
+func (v *tweetView) QuoteCount() int { n, _ := v.count("quote_count"); return n }
+
+// The counts present in the tweet, as "retweets 3 likes 10 ...".
+func (v *tweetView) Counts() string {
+	var counts []string
+	for _, c := range []struct{ name, fieldName string }{
+		{"retweets", "retweet_count"},
+		{"likes", "favorite_count"},
+		{"replies", "reply_count"},
+		{"quotes", "quote_count"},
+	} {
+		if n, ok := v.count(c.fieldName); ok {
+			counts = append(counts, fmt.Sprintf("%s %d", c.name, n))
+		}
+	}
+	return strings.Join(counts, " ")
+}
+
+func (v *tweetView) Lang() string {
+	return v.Tweet.Language()
+}
+
+// The client used to post the tweet.
+func (v *tweetView) Source() string {
+	source, _ := get(v.Tweet, "source")
+	return html.UnescapeString(htmlTagExpr.ReplaceAllString(source, ""))
+}
+
+func (v *tweetView) Edited() bool {
+	history, _ := v.Tweet["edit_history"].(map[string]interface{})
+	ids, _ := history["edit_tweet_ids"].([]interface{})
+	return len(ids) > 1
+}
+
+func (v *tweetView) Sensitive() bool {
+	sensitive, _ := v.Tweet["possibly_sensitive"].(bool)
+	return sensitive
+}
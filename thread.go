@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Separates the tweets of a thread, on a line by itself.
+const threadDelimiter = "---"
+
+// Optional first line of a thread, to post it as a reply, e.g., to
+// continue a thread that was interrupted.
+var threadReplyExpr = regexp.MustCompile(`^reply ([0-9]+)\s*$`)
+
+// A thread being posted, each tweet a reply to the previous one. The
+// tweets posted are kept, so that posting can be resumed after a
+// failure.
+type thread struct {
+	inReply string
+	parts   []string
+	posted  []*node
+
+	// Whether parts can have a header, as in the thread file.
+	headers bool
+}
+
+func parseThread(text string, headers bool) (*thread, error) {
+	th := &thread{headers: headers}
+	lines := strings.Split(text, "\n")
+	if m := threadReplyExpr.FindStringSubmatch(lines[0]); m != nil {
+		th.inReply = m[1]
+		lines = lines[1:]
+	}
+	var part []string
+	flush := func() {
+		if s := strings.TrimSpace(strings.Join(part, "\n")); s != "" {
+			th.parts = append(th.parts, s)
+		}
+		part = nil
+	}
+	for _, line := range lines {
+		if strings.TrimRight(line, " \t\r") == threadDelimiter {
+			flush()
+		} else {
+			part = append(part, line)
+		}
+	}
+	flush()
+	if len(th.parts) == 0 {
+		return nil, errors.New("nothing to post")
+	}
+	return th, nil
+}
+
+// Posts the tweets of the thread not posted yet. On failure, the error
+// tells which were posted, and how to continue.
+func (fs *fsOps) postThread(th *thread) error {
+	for len(th.posted) < len(th.parts) {
+		inReply := th.inReply
+		if len(th.posted) > 0 {
+			inReply = th.posted[len(th.posted)-1].dir.Name
+		}
+		o := &outgoing{text: th.parts[len(th.posted)]}
+		if th.headers {
+			var err error
+			if o, err = parseOutgoing(o.text); err != nil {
+				return th.progressError(err)
+			}
+		}
+		if inReply != "" {
+			o.inReply = inReply
+		}
+		tweet, err := fs.send(o)
+		if err != nil {
+			return th.progressError(err)
+		}
+		th.posted = append(th.posted, tweet)
+	}
+	return nil
+}
+
+func (th *thread) progressError(err error) error {
+	if len(th.posted) == 0 {
+		return errors.Wrapf(err, "posted none of %d tweets", len(th.parts))
+	}
+	var ids []string
+	for _, tweet := range th.posted {
+		ids = append(ids, tweet.dir.Name)
+	}
+	return errors.Wrapf(err, "posted %d of %d tweets (%s), continue with %q",
+		len(th.posted), len(th.parts), strings.Join(ids, " "), "reply "+ids[len(ids)-1])
+}
+
+// The paths of the tweets posted, one per line.
+func (th *thread) paths() []byte {
+	var b strings.Builder
+	for _, tweet := range th.posted {
+		_, _ = fmt.Fprintln(&b, tweetPath(tweet))
+	}
+	return []byte(b.String())
+}
+
+// Posts the thread written to a clone of the thread file. Reading the
+// file again after a failure resumes posting where it stopped.
+func (fs *fsOps) sendThread(n *node) error {
+	if n.thread == nil {
+		th, err := parseThread(string(n.buffer), true)
+		if err != nil {
+			return err
+		}
+		n.thread = th
+	}
+	err := fs.postThread(n.thread)
+	n.buffer = n.thread.paths()
+	n.dir.Length = uint64(len(n.buffer))
+	if err != nil {
+		return err
+	}
+	n.loaded = true
+	return nil
+}
+
+func (fs *fsOps) ctlThread(_ *node, args []string) error {
+	th, err := parseThread(args[0], false)
+	if err != nil {
+		return err
+	}
+	return fs.postThread(th)
+}
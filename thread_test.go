@@ -0,0 +1,89 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseThread(t *testing.T) {
+	testCases := []struct {
+		text    string
+		inReply string
+		parts   []string
+	}{
+		{"one", "", []string{"one"}},
+		{"one\n---\ntwo\n", "", []string{"one", "two"}},
+		{"one\nstill one\n---  \n\ntwo\n---\n", "", []string{"one\nstill one", "two"}},
+		{"reply 1274574891338129409\none\n---\n---\ntwo", "1274574891338129409", []string{"one", "two"}},
+		{"one\n----\ntwo", "", []string{"one\n----\ntwo"}},
+	}
+	for _, tc := range testCases {
+		th, err := parseThread(tc.text, false)
+		if err != nil {
+			t.Errorf("%q: %v", tc.text, err)
+			continue
+		}
+		if th.inReply != tc.inReply || !reflect.DeepEqual(th.parts, tc.parts) {
+			t.Errorf("%q: got %q and %q, want %q and %q", tc.text, th.inReply, th.parts, tc.inReply, tc.parts)
+		}
+	}
+	for _, bad := range []string{"", "\n---\n\n", "reply 1274574891338129409\n"} {
+		if _, err := parseThread(bad, false); err == nil {
+			t.Errorf("%q: no error", bad)
+		}
+	}
+}
+
+func TestPostThread(t *testing.T) {
+	fake := newFakeTwitter()
+	defer fake.close()
+	fs, cleanup := newTestFileSystem(t, fake.client(), &fsConfig{})
+	defer cleanup()
+	n := fs.root.children["thread"].clonePost()
+	n.buffer = []byte("one\n---\ntwo\n---\nthree\n")
+
+	// Interrupted after the first tweet.
+	fake.updatesLeft = 1
+	err := fs.ensureLoaded(n)
+	if err == nil {
+		t.Fatal("no error")
+	}
+	if len(n.thread.posted) != 1 {
+		t.Fatalf("posted %d tweets", len(n.thread.posted))
+	}
+	first := n.thread.posted[0].dir.Name
+	if msg := err.Error(); !strings.Contains(msg, "posted 1 of 3 tweets ("+first+")") || !strings.Contains(msg, `"reply `+first+`"`) {
+		t.Errorf("got error %q", msg)
+	}
+	if got, want := string(n.buffer), "/users/janet/"+first+"\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	// Reading again resumes.
+	fake.updatesLeft = 0
+	if err := fs.ensureLoaded(n); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if got := strings.Count(string(n.buffer), "\n"); got != 3 {
+		t.Errorf("got %d paths in %q", got, n.buffer)
+	}
+	for i, tweet := range n.thread.posted {
+		if got, want := tweet.data.FullText(), n.thread.parts[i]; got != want {
+			t.Errorf("tweet %d: got %q, want %q", i, got, want)
+		}
+		inReply, _ := get(tweet.data, "in_reply_to_status_id_str")
+		if i > 0 && inReply != n.thread.posted[i-1].dir.Name || i == 0 && inReply != "" {
+			t.Errorf("tweet %d: got reply to %q", i, inReply)
+		}
+	}
+
+	// A thread can continue another one.
+	last := n.thread.posted[2].dir.Name
+	if err := fs.runCtl(fs.root, "thread 'reply "+last+"\nfour\n---\nfive'"); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(fs.root.children["users"].children["janet"].children); got != 5 {
+		t.Errorf("got %d tweets, want 5", got)
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Keeps track of which tweets have been read. Each timeline has a read
+// marker: tweets up to and including the marker are read. Tweets past
+// the marker are read if they've been read individually, i.e., their
+// file was read to the end, in any timeline.
+//
+// The state is saved to a file with lines like
+//
+//	marker home 1271800794002710540
+//	marker @janet 1274574891338129409
+//	read 1274574891338129999
+//
+// Tweets read individually are kept apart from the tweet store, so that
+// they're still read once loaded again, but at most maxReadTweets of
+// them, forgetting the oldest first.
+type readState struct {
+	path    string
+	markers map[string]string
+	read    map[string]struct{}
+}
+
+const maxReadTweets = 10000
+
+func loadReadState(path string) (*readState, error) {
+	s := &readState{
+		path:    path,
+		markers: make(map[string]string),
+		read:    make(map[string]struct{}),
+	}
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		switch {
+		case len(fields) == 3 && fields[0] == "marker":
+			s.markers[fields[1]] = fields[2]
+		case len(fields) == 2 && fields[0] == "read":
+			s.read[fields[1]] = struct{}{}
+		case len(fields) == 0:
+		default:
+			return nil, errors.Errorf("%s: bad line %q", path, scanner.Text())
+		}
+	}
+	return s, errors.WithStack(scanner.Err())
+}
+
+func (s *readState) save() error {
+	var b bytes.Buffer
+	var timelines []string
+	for timeline := range s.markers {
+		timelines = append(timelines, timeline)
+	}
+	sort.Strings(timelines)
+	for _, timeline := range timelines {
+		_, _ = fmt.Fprintf(&b, "marker %s %s\n", timeline, s.markers[timeline])
+	}
+	for idStr := range s.read {
+		_, _ = fmt.Fprintf(&b, "read %s\n", idStr)
+	}
+	return errors.WithStack(ioutil.WriteFile(s.path, b.Bytes(), 0600))
+}
+
+// Compares tweet ids numerically.
+func idLess(a, b string) bool {
+	if len(a) != len(b) {
+		return len(a) < len(b)
+	}
+	return a < b
+}
+
+func (s *readState) isUnread(timeline string, idStr string) bool {
+	if marker, ok := s.markers[timeline]; ok && !idLess(marker, idStr) {
+		return false
+	}
+	_, ok := s.read[idStr]
+	return !ok
+}
+
+// Marks a single tweet as read, in all timelines. Reports whether that
+// changed anything.
+func (s *readState) markRead(idStr string) bool {
+	if _, ok := s.read[idStr]; ok {
+		return false
+	}
+	s.read[idStr] = struct{}{}
+	if len(s.read) > maxReadTweets {
+		oldest := idStr
+		for other := range s.read {
+			if idLess(other, oldest) {
+				oldest = other
+			}
+		}
+		delete(s.read, oldest)
+	}
+	return true
+}
+
+// Moves the read marker of the timeline, marking as read all its tweets
+// up to and including the given one, and as unread the ones after it,
+// unless read individually.
+func (s *readState) setMarker(timeline string, idStr string) {
+	s.markers[timeline] = idStr
+}
+
+// Rebuilds the listing of an unread directory from its timeline.
+func (s *readState) refresh(dir *node) {
+	timeline := dir.parent
+	name := timeline.timelineName()
+	dir.children = make(map[string]*node)
+	for idStr, tweet := range timeline.children {
+		if s.isUnread(name, idStr) {
+			dir.children[idStr] = tweet
+		}
+	}
+	dir.prepareDirEntries()
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUnread(t *testing.T) {
+	defer func(delay time.Duration) { readSaveDelay = delay }(readSaveDelay)
+	readSaveDelay = 10 * time.Millisecond
+	fake := newFakeTwitter()
+	defer fake.close()
+	fs, cleanup := newTestFileSystem(t, nil, &fsConfig{})
+	defer cleanup()
+	home := fs.root.children["home"]
+	unread := home.files["unread"]
+	withMedia := fake.addTweet("john", "Look")
+	withMedia["extended_entities"] = map[string]interface{}{
+		"media": []interface{}{map[string]interface{}{"media_url_https": "https://pbs.twimg.com/media/1.jpg"}},
+	}
+	plain := home.addTweet(fs.tweets, fake.addTweet("mary", "Hello"))
+	home.addTweet(fs.tweets, withMedia)
+
+	// Only the tweets are listed, not their media directories, which
+	// can't be walked to from unread.
+	fs.readState.refresh(unread)
+	if len(unread.children) != 2 || len(unread.boundaries) != 2 {
+		t.Errorf("got %d unread tweets and %d entries, want 2 and 2", len(unread.children), len(unread.boundaries))
+	}
+
+	// Reads are saved in a batch.
+	fs.markRead(plain)
+	fs.markRead(home.children[withMedia.IdStr()])
+	fs.unlocked(func() { time.Sleep(50 * time.Millisecond) })
+	b, err := ioutil.ReadFile(fs.readState.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Count(string(b), "read "); got != 2 {
+		t.Errorf("got %d tweets saved as read, want 2", got)
+	}
+	fs.readState.refresh(unread)
+	if len(unread.children) != 0 {
+		t.Errorf("got %d unread tweets, want none", len(unread.children))
+	}
+
+	// Tweets read stay read once trimmed and loaded again, e.g., on
+	// reload.
+	home.trim(fs.tweets, 0)
+	home.addTweet(fs.tweets, plain.data)
+	fs.readState.refresh(unread)
+	if len(fs.readState.read) != 2 || len(unread.children) != 0 {
+		t.Errorf("got %d tweets read, %d unread, want 2 and none", len(fs.readState.read), len(unread.children))
+	}
+}
+
+func TestReadStateBounded(t *testing.T) {
+	s, err := loadReadState("/nonexistent/read")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= maxReadTweets+1; i++ {
+		s.markRead(strconv.Itoa(1000000 + i))
+	}
+	if len(s.read) != maxReadTweets {
+		t.Errorf("got %d tweets read, want %d", len(s.read), maxReadTweets)
+	}
+	if _, ok := s.read["1000001"]; ok {
+		t.Error("oldest tweet read not forgotten")
+	}
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kurrik/twittergo"
+	"github.com/pkg/errors"
+)
+
+// Media are uploaded to a different host than the rest of the API.
+var uploadHost = "upload.twitter.com"
+
+const (
+	// Photos up to this size are uploaded in one request, anything
+	// else in chunks of uploadChunkSize.
+	simpleUploadLimit = 5 << 20
+	uploadChunkSize   = 1 << 20
+
+	// How many times to ask whether an upload was processed.
+	maxUploadChecks = 60
+)
+
+// A file to attach to a tweet, with its alt text.
+type attachment struct {
+	path string
+	alt  string
+}
+
+// The upload categories, by media type.
+func mediaCategory(mediaType string) (string, error) {
+	switch {
+	case mediaType == "image/gif":
+		return "tweet_gif", nil
+	case strings.HasPrefix(mediaType, "image/"):
+		return "tweet_image", nil
+	case strings.HasPrefix(mediaType, "video/"):
+		return "tweet_video", nil
+	default:
+		return "", errors.Errorf("%s: media type not supported", mediaType)
+	}
+}
+
+// Uploads the file and sets its alt text, if any. Returns the media
+// id to attach to a tweet.
+func uploadAttachment(client *twittergo.Client, a attachment) (string, error) {
+	if !filepath.IsAbs(a.path) {
+		return "", errors.Errorf("%q: not an absolute path", a.path)
+	}
+	data, err := ioutil.ReadFile(a.path)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	mediaID, err := apiMediaUpload(client, data)
+	if err != nil {
+		return "", errors.Wrapf(err, "%s", a.path)
+	}
+	if a.alt != "" {
+		if err := apiMediaMetadataCreate(client, mediaID, a.alt); err != nil {
+			return "", errors.Wrapf(err, "%s", a.path)
+		}
+	}
+	return mediaID, nil
+}
+
+type uploadResponse struct {
+	MediaIDString  string `json:"media_id_string"`
+	ProcessingInfo *struct {
+		State          string `json:"state"`
+		CheckAfterSecs int    `json:"check_after_secs"`
+		Error          struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	} `json:"processing_info"`
+}
+
+// Checks the response to a request returning no content.
+func parseEmptyResponse(response *twittergo.APIResponse) error {
+	if response.StatusCode >= 200 && response.StatusCode < 300 {
+		_ = response.ReadBody()
+		return nil
+	}
+	return errors.WithStack(response.Parse(nil))
+}
+
+// Sends a request to the upload endpoint, with the given parameters
+// and, if not nil, media data. The response is parsed into out, if not
+// nil.
+func sendUploadRequest(client *twittergo.Client, method string, params url.Values, data []byte, out *uploadResponse) error {
+	var body bytes.Buffer
+	var contentType string
+	if data != nil {
+		w := multipart.NewWriter(&body)
+		part, err := w.CreateFormFile("media", "media")
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		if _, err := part.Write(data); err != nil {
+			return errors.WithStack(err)
+		}
+		if err := w.Close(); err != nil {
+			return errors.WithStack(err)
+		}
+		contentType = w.FormDataContentType()
+	}
+	u := "https://" + uploadHost + "/1.1/media/upload.json"
+	if len(params) > 0 {
+		u += "?" + params.Encode()
+	}
+	request, err := http.NewRequest(method, u, &body)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if contentType != "" {
+		request.Header.Set("Content-Type", contentType)
+	}
+	response, err := client.SendRequest(request)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if out == nil {
+		return parseEmptyResponse(response)
+	}
+	return errors.WithStack(response.Parse(out))
+}
+
+// Uploads photos in one request, GIFs, videos and large photos in
+// chunks, as per
+// https://developer.twitter.com/en/docs/media/upload-media/uploading-media/chunked-media-upload.
+func apiMediaUpload(client *twittergo.Client, data []byte) (string, error) {
+	mediaType := http.DetectContentType(data)
+	category, err := mediaCategory(mediaType)
+	if err != nil {
+		return "", err
+	}
+	var obj uploadResponse
+	if category == "tweet_image" && len(data) <= simpleUploadLimit {
+		if err := sendUploadRequest(client, http.MethodPost, nil, data, &obj); err != nil {
+			return "", err
+		}
+		return obj.MediaIDString, nil
+	}
+	params := url.Values{}
+	params.Set("command", "INIT")
+	params.Set("total_bytes", strconv.Itoa(len(data)))
+	params.Set("media_type", mediaType)
+	params.Set("media_category", category)
+	if err := sendUploadRequest(client, http.MethodPost, params, nil, &obj); err != nil {
+		return "", err
+	}
+	mediaID := obj.MediaIDString
+	for i := 0; i*uploadChunkSize < len(data); i++ {
+		end := (i + 1) * uploadChunkSize
+		if end > len(data) {
+			end = len(data)
+		}
+		params := url.Values{}
+		params.Set("command", "APPEND")
+		params.Set("media_id", mediaID)
+		params.Set("segment_index", strconv.Itoa(i))
+		if err := sendUploadRequest(client, http.MethodPost, params, data[i*uploadChunkSize:end], nil); err != nil {
+			return "", err
+		}
+	}
+	params = url.Values{}
+	params.Set("command", "FINALIZE")
+	params.Set("media_id", mediaID)
+	obj = uploadResponse{}
+	if err := sendUploadRequest(client, http.MethodPost, params, nil, &obj); err != nil {
+		return "", err
+	}
+	// Videos and GIFs are processed before they can be attached.
+	for checks := 0; obj.ProcessingInfo != nil; checks++ {
+		switch obj.ProcessingInfo.State {
+		case "succeeded":
+			return mediaID, nil
+		case "failed":
+			return "", errors.Errorf("processing failed: %s", obj.ProcessingInfo.Error.Message)
+		}
+		if checks == maxUploadChecks {
+			return "", errors.Errorf("still processing after %d checks", checks)
+		}
+		time.Sleep(time.Duration(obj.ProcessingInfo.CheckAfterSecs) * time.Second)
+		params := url.Values{}
+		params.Set("command", "STATUS")
+		params.Set("media_id", mediaID)
+		obj = uploadResponse{}
+		if err := sendUploadRequest(client, http.MethodGet, params, nil, &obj); err != nil {
+			return "", err
+		}
+	}
+	return mediaID, nil
+}
+
+func apiMediaMetadataCreate(client *twittergo.Client, mediaID string, altText string) error {
+	var obj struct {
+		MediaID string `json:"media_id"`
+		AltText struct {
+			Text string `json:"text"`
+		} `json:"alt_text"`
+	}
+	obj.MediaID = mediaID
+	obj.AltText.Text = altText
+	body, err := json.Marshal(obj)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	request, err := http.NewRequest(http.MethodPost, "https://"+uploadHost+"/1.1/media/metadata/create.json", bytes.NewReader(body))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	request.Header.Set("Content-Type", "application/json")
+	response, err := client.SendRequest(request)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return parseEmptyResponse(response)
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseOutgoing(t *testing.T) {
+	for _, c := range []struct {
+		text  string
+		want  string
+		media []attachment
+	}{
+		{"Hello, world\n", "Hello, world", nil},
+		{"Note: not a header\n", "Note: not a header", nil},
+		{"Media: /tmp/a.png\n\nCaption\n", "Caption", []attachment{{path: "/tmp/a.png"}}},
+		{"media: /tmp/a.png\nALT: A cat, asleep \nMedia: /tmp/b.png\n", "", []attachment{{"/tmp/a.png", "A cat, asleep"}, {path: "/tmp/b.png"}}},
+		// Headers need a blank line after them, or they're text.
+		{"Media: /tmp/a.png\nCaption\n", "Media: /tmp/a.png\nCaption", nil},
+		{"Media: overrated", "Media: overrated", nil},
+		{"Quote: be kind\nNote: not a header\n\nText", "Quote: be kind\nNote: not a header\n\nText", nil},
+		{"\nMedia: /tmp/a.png\n", "Media: /tmp/a.png", nil},
+	} {
+		o, err := parseOutgoing(c.text)
+		if err != nil {
+			t.Errorf("%q: %v", c.text, err)
+			continue
+		}
+		if o.text != c.want || !reflect.DeepEqual(o.media, c.media) {
+			t.Errorf("%q: got %q %v, want %q %v", c.text, o.text, o.media, c.want, c.media)
+		}
+	}
+	if _, err := parseOutgoing("Alt: Nothing\n\nText"); err == nil {
+		t.Error("alt text without media")
+	}
+	for text, want := range map[string]string{
+		"Hello":                "Hello",
+		"Quote: be kind\n\nOK": "\nQuote: be kind\n\nOK",
+		"Alt: Nothing\n\nText": "\nAlt: Nothing\n\nText",
+	} {
+		if got := literal(text); got != want {
+			t.Errorf("literal(%q): got %q, want %q", text, got, want)
+		}
+	}
+}
+
+func TestUpload(t *testing.T) {
+	fake := newFakeTwitter()
+	defer fake.close()
+	fs, cleanup := newTestFileSystem(t, fake.client(), &fsConfig{})
+	defer cleanup()
+
+	dir, err := ioutil.TempDir(fs.config.dir, "media")
+	if err != nil {
+		t.Fatal(err)
+	}
+	photo := filepath.Join(dir, "cat.png")
+	photoData := append([]byte("\x89PNG\r\n\x1a\n"), make([]byte, 1000)...)
+	video := filepath.Join(dir, "clip.mp4")
+	videoData := append([]byte("\x00\x00\x00\x18ftypmp42"), bytes.Repeat([]byte{1}, 5*uploadChunkSize/2)...)
+	text := filepath.Join(dir, "notes.txt")
+	for path, data := range map[string][]byte{photo: photoData, video: videoData, text: []byte("just text")} {
+		if err := ioutil.WriteFile(path, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	post := fs.root.children["post"].clonePost()
+	post.buffer = []byte("Media: " + photo + "\nAlt: A cat\nMedia: " + video + "\n\nLook\n")
+	if err := fs.ensureLoaded(post); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	idStr := strings.TrimPrefix(strings.TrimSuffix(string(post.buffer), "\n"), "/users/janet/")
+	tweet := fake.tweets[idStr]
+	if got := tweet.FullText(); got != "Look" {
+		t.Errorf("posted %q", got)
+	}
+	media := tweet.ExtendedEntities().Media()
+	if len(media) != 2 {
+		t.Fatalf("got %d media, want 2", len(media))
+	}
+	photoUpload := fake.uploads[media[0]["id_str"].(string)]
+	if photoUpload.chunked || photoUpload.alt != "A cat" || !bytes.Equal(photoUpload.segments[0], photoData) {
+		t.Errorf("got photo upload %+v", photoUpload)
+	}
+	videoUpload := fake.uploads[media[1]["id_str"].(string)]
+	if !videoUpload.chunked || videoUpload.mediaType != "video/mp4" || videoUpload.category != "tweet_video" {
+		t.Errorf("got video upload %+v", videoUpload)
+	}
+	if len(videoUpload.segments) != 3 || !bytes.Equal(bytes.Join(videoUpload.segments, nil), videoData) {
+		t.Errorf("got %d segments, %d bytes", len(videoUpload.segments), videoUpload.size())
+	}
+	if !videoUpload.checked {
+		t.Error("video processing status not checked")
+	}
+
+	// Nothing is posted if an upload fails.
+	n := len(fake.tweets)
+	for _, bad := range []string{
+		"Media: cat.png\n\nRelative",
+		"Media: " + filepath.Join(dir, "missing.png") + "\n\nMissing",
+		"Media: " + text + "\n\nNot media",
+	} {
+		post := fs.root.children["post"].clonePost()
+		post.buffer = []byte(bad)
+		if err := fs.ensureLoaded(post); err == nil {
+			t.Errorf("%q: posted", bad)
+		}
+	}
+	if len(fake.tweets) != n {
+		t.Errorf("got %d tweets, want %d", len(fake.tweets), n)
+	}
+
+	// Text posted via the control file has no header.
+	if err := fs.runCtl(fs.root, "post "+quoteCtlArg("Media: "+photo+"\n\nLook")); err != nil {
+		t.Fatal(err)
+	}
+	literal := false
+	for _, tweet := range fake.tweets {
+		if tweet.FullText() == "Media: "+photo+"\n\nLook" && len(tweet.ExtendedEntities().Media()) == 0 {
+			literal = true
+		}
+	}
+	if !literal {
+		t.Error("control file text taken for a header")
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Ranges of runes taking two columns in terminals and acme with
+// fixed-width fonts: East Asian wide and full-width characters, and
+// emoji.
+var wideRanges = [][2]rune{
+	{0x1100, 0x115f},
+	{0x231a, 0x231b},
+	{0x23e9, 0x23ec},
+	{0x25fd, 0x25fe},
+	{0x2614, 0x2615},
+	{0x2648, 0x2653},
+	{0x26aa, 0x26ab},
+	{0x26bd, 0x26be},
+	{0x26c4, 0x26c5},
+	{0x26f2, 0x26f5},
+	{0x2705, 0x2705},
+	{0x270a, 0x270b},
+	{0x2728, 0x2728},
+	{0x274c, 0x274c},
+	{0x2753, 0x2757},
+	{0x2795, 0x2797},
+	{0x2b1b, 0x2b1c},
+	{0x2e80, 0x303e},
+	{0x3041, 0x33ff},
+	{0x3400, 0x4dbf},
+	{0x4e00, 0x9fff},
+	{0xa000, 0xa4cf},
+	{0xa960, 0xa97f},
+	{0xac00, 0xd7a3},
+	{0xf900, 0xfaff},
+	{0xfe10, 0xfe19},
+	{0xfe30, 0xfe6f},
+	{0xff00, 0xff60},
+	{0xffe0, 0xffe6},
+	{0x1f004, 0x1f004},
+	{0x1f0cf, 0x1f0cf},
+	{0x1f18e, 0x1f18e},
+	{0x1f191, 0x1f19a},
+	{0x1f200, 0x1f2ff},
+	{0x1f300, 0x1f3fa},
+	{0x1f400, 0x1f64f},
+	{0x1f680, 0x1f6ff},
+	{0x1f7e0, 0x1f7eb},
+	{0x1f90c, 0x1f9ff},
+	{0x1fa70, 0x1faff},
+	{0x20000, 0x3fffd},
+}
+
+func runeWidth(r rune) int {
+	switch {
+	case r == 0x200d, r >= 0x1f3fb && r <= 0x1f3ff:
+		// Zero width joiner, skin tone modifiers.
+		return 0
+	case unicode.In(r, unicode.Mn, unicode.Me, unicode.Cf):
+		// Combining marks, variation selectors, and such.
+		return 0
+	}
+	for _, wide := range wideRanges {
+		if r < wide[0] {
+			break
+		}
+		if r <= wide[1] {
+			return 2
+		}
+	}
+	return 1
+}
+
+func stringWidth(s string) int {
+	width := 0
+	for _, r := range s {
+		width += runeWidth(r)
+	}
+	return width
+}
+
+// URLs and paths are never broken, even if longer than a line.
+func isUnbreakable(word string) bool {
+	return strings.Contains(word, "://") || strings.Contains(word, "/")
+}
+
+// Wraps text so that lines are at most the given number of columns
+// wide, if possible. Words are broken only if longer than a line,
+// which is common with Chinese and Japanese, and unless URLs or paths.
+// Line breaks in the text are kept.
+func wrapText(text string, columns int) string {
+	if columns <= 0 {
+		return text
+	}
+	var b strings.Builder
+	for i, paragraph := range strings.Split(text, "\n") {
+		if i > 0 {
+			b.WriteByte('\n')
+		}
+		width := 0
+		for _, word := range strings.Fields(paragraph) {
+			wordWidth := stringWidth(word)
+			if width > 0 && width+1+wordWidth <= columns {
+				b.WriteByte(' ')
+				width++
+			} else if width > 0 {
+				b.WriteByte('\n')
+				width = 0
+			}
+			if wordWidth <= columns-width || isUnbreakable(word) {
+				b.WriteString(word)
+				width += wordWidth
+				continue
+			}
+			for _, r := range word {
+				if w := runeWidth(r); width+w > columns && width > 0 {
+					b.WriteByte('\n')
+					width = 0
+				}
+				b.WriteRune(r)
+				width += runeWidth(r)
+			}
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWrapText(t *testing.T) {
+	testCases := []struct {
+		text    string
+		columns int
+		want    string
+	}{
+		{"short", 10, "short"},
+		{"the quick brown fox jumps", 10, "the quick\nbrown fox\njumps"},
+		{"keep\nline  breaks", 80, "keep\nline breaks"},
+		{"see https://example.com/a/very/long/path/indeed ok", 10, "see\nhttps://example.com/a/very/long/path/indeed\nok"},
+		{"in ../netbsdsrc/1271800794002710540 now", 12, "in\n../netbsdsrc/1271800794002710540\nnow"},
+		{"日本語のテキストです", 8, "日本語の\nテキスト\nです"},
+		{"ab 😀😀 cd", 6, "ab\n😀😀\ncd"},
+		{"👍🏽 ok", 5, "👍🏽 ok"},
+		{"abcdefghij", 4, "abcd\nefgh\nij"},
+	}
+	for _, tc := range testCases {
+		got := wrapText(tc.text, tc.columns)
+		if got != tc.want {
+			t.Errorf("wrapText(%q, %d): got %q, want %q", tc.text, tc.columns, got, tc.want)
+		}
+		for _, line := range strings.Split(got, "\n") {
+			if w := stringWidth(line); w > tc.columns && !isUnbreakable(line) {
+				t.Errorf("wrapText(%q, %d): line %q is %d columns wide", tc.text, tc.columns, line, w)
+			}
+		}
+	}
+}